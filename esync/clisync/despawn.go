@@ -0,0 +1,24 @@
+package clisync
+
+// DespawnMode decides what happens to the client entity when a networked entity is despawned
+// by the server, or is no longer part of the snapshots.
+type DespawnMode int
+
+const (
+	// DespawnRemove removes the entity from the world, including its client-only components.
+	// This is the default.
+	DespawnRemove DespawnMode = iota
+	// DespawnStripReplicated removes the replicated components and the network ID from the
+	// entity, leaving an entity with only its client-only components.
+	DespawnStripReplicated
+	// DespawnKeep only removes the network ID from the entity and leaves all of its components,
+	// user code can then decide what happens to it in an [OnNetworkDespawn] callback.
+	DespawnKeep
+)
+
+var despawnMode = DespawnRemove
+
+// SetDespawnMode sets what happens to the client entity of a networked entity that goes away.
+func SetDespawnMode(mode DespawnMode) {
+	despawnMode = mode
+}
@@ -0,0 +1,19 @@
+// Package clisync applies the snapshots sent by srvsync to the client world.
+//
+// # Replicated and client-only components
+//
+// Components that the server synchronizes are replicated components, any other component
+// added to a networked entity on the client is a client-only component, such as sprites or
+// particle emitters. clisync only ever writes replicated components:
+//
+//   - Entities are created with the replicated components of their first snapshot, client-only
+//     components can be added in an [OnNetworkSpawn] callback or through a prefab.
+//   - Snapshots only overwrite the replicated components they contain, client-only components
+//     are left as they are.
+//   - When the server stops synchronizing a component, it is removed from the client entity.
+//     clisync keeps track of the components it has received, components that were never
+//     received from the server are never removed.
+//
+// What happens when a networked entity goes away is decided by the [DespawnMode], the
+// [OnNetworkDespawn] callbacks are always called first.
+package clisync
@@ -0,0 +1,101 @@
+package clisync
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/leap-fish/necs/esync"
+	"github.com/leap-fish/necs/router"
+)
+
+var (
+	ErrUnknownComponent = errors.New("unknown component")
+	ErrDecode           = errors.New("unable to decode component")
+	ErrUnknownInterpKey = errors.New("unknown interpolation key")
+)
+
+// SyncError is reported when part of a snapshot could not be applied to the world.
+// Use errors.Is with [ErrUnknownComponent], [ErrDecode] or [ErrUnknownInterpKey] to check its kind.
+type SyncError struct {
+	Kind        error
+	NetworkId   esync.NetworkId
+	ComponentId esync.ComponentId
+	// InterpKey is set for [ErrUnknownInterpKey] errors, where the component ID is not known.
+	InterpKey uint8
+	Err       error
+}
+
+func (e *SyncError) Error() string {
+	if e.Kind == ErrUnknownInterpKey {
+		return fmt.Sprintf("%s: network id %d, interpolation key %d", e.Kind, e.NetworkId, e.InterpKey)
+	}
+
+	msg := fmt.Sprintf("%s: network id %d, component id %d", e.Kind, e.NetworkId, e.ComponentId)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *SyncError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// ErrorAction is returned by an [ErrorPolicy] to decide how to continue after an error.
+type ErrorAction int
+
+const (
+	// Skip ignores the part of the snapshot that failed and continues with the rest of it.
+	Skip ErrorAction = iota
+	// Disconnect stops applying the snapshot and closes the connection to the server.
+	Disconnect
+)
+
+// ErrorPolicy is called for every [SyncError], after the error has been passed to the
+// [router.OnError] callbacks. Custom policies can be used to inspect errors and decide
+// per error whether to skip it or disconnect.
+type ErrorPolicy func(sender *router.NetworkClient, err *SyncError) ErrorAction
+
+// SkipErrors is the default [ErrorPolicy], it skips anything that cannot be applied.
+func SkipErrors(sender *router.NetworkClient, err *SyncError) ErrorAction {
+	return Skip
+}
+
+// DisconnectOnError is an [ErrorPolicy] which disconnects from the server on any error.
+func DisconnectOnError(sender *router.NetworkClient, err *SyncError) ErrorAction {
+	return Disconnect
+}
+
+var (
+	errorPolicy ErrorPolicy = SkipErrors
+
+	// server is the connection snapshots were last received from, errors that happen outside
+	// of snapshot handling such as in the interpolation system are reported against it.
+	server atomic.Pointer[router.NetworkClient]
+)
+
+// SetErrorPolicy sets the policy deciding what happens when a snapshot cannot be applied.
+func SetErrorPolicy(policy ErrorPolicy) {
+	if policy == nil {
+		policy = SkipErrors
+	}
+	errorPolicy = policy
+}
+
+// handleError reports the error and returns true if applying the snapshot should be aborted.
+func handleError(sender *router.NetworkClient, err *SyncError) bool {
+	router.ReportError(sender, err)
+
+	if errorPolicy(sender, err) != Disconnect {
+		return false
+	}
+
+	if sender != nil {
+		_ = sender.Disconnect(err.Error())
+	}
+	return true
+}
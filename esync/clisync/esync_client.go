@@ -1,8 +1,10 @@
 package clisync
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
+	"slices"
 	"time"
 
 	"github.com/leap-fish/necs/esync"
@@ -10,121 +12,315 @@ import (
 	"github.com/yohamta/donburi"
 )
 
-const MaxHistorySize = 32
+func init() {
+	// The server down-converts the components of snapshots to the versions announced here.
+	router.AddManifest(esync.ManifestName, esync.Mapper.Manifest)
+}
+
+// replicaComponent keeps the last received state of every replicated component of an entity,
+// it is used to tell which components actually changed between snapshots.
+var replicaComponent = donburi.NewComponentType[replicaData]()
+
+type replicaData struct {
+	state map[esync.ComponentId][]byte
+}
+
+// receivedComponent is a component of a snapshot that has been decoded.
+type receivedComponent struct {
+	id    esync.ComponentId
+	data  []byte
+	value any
+}
 
-func clientUpdateWorldState(world donburi.World, state esync.WorldSnapshot) error {
+// clientUpdateWorldState applies the snapshot to the world, it returns false if applying
+// it was aborted by the error policy.
+func clientUpdateWorldState(world donburi.World, sender *router.NetworkClient, state esync.WorldSnapshot) bool {
 	for _, ent := range state {
-		var components []any
+		components := make([]receivedComponent, 0, len(ent.State))
 		for componentId, componentBytes := range ent.State {
+			if esync.Mapper.Lookup(uint(componentId)) == nil {
+				if handleError(sender, &SyncError{Kind: ErrUnknownComponent, NetworkId: ent.Id, ComponentId: componentId}) {
+					return false
+				}
+				continue
+			}
+
 			instance, err := esync.Mapper.Deserialize(componentBytes)
 			if err != nil {
-				return fmt.Errorf("unable to deserialize component id: %d: %w", componentId, err)
+				if handleError(sender, &SyncError{Kind: ErrDecode, NetworkId: ent.Id, ComponentId: componentId, Err: err}) {
+					return false
+				}
+				continue
 			}
-			components = append(components, instance)
+			components = append(components, receivedComponent{id: componentId, data: componentBytes, value: instance})
 		}
 		// For entities that are in the world snapshot:
-		applyEntityDiff(world, ent.Id, components)
+		if !applyEntityDiff(world, sender, ent, components) {
+			return false
+		}
 	}
 
-	return nil
+	return true
 }
 
-func applyEntityDiff(world donburi.World, networkId esync.NetworkId, components []any) {
-	ctypes := make([]donburi.IComponentType, 0)
-	refTypes := make([]reflect.Type, len(components))
+// applyEntityDiff applies the decoded components of the serialized entity to the entity with
+// its network ID, it returns false if applying the snapshot was aborted by the error policy.
+func applyEntityDiff(world donburi.World, sender *router.NetworkClient, ent esync.SerializedEntity, components []receivedComponent) bool {
+	networkId := ent.Id
+	ctypes := make([]donburi.IComponentType, 0, len(components)+1)
+	records := make([]esync.Record, 0, len(components))
+	received := make([]receivedComponent, 0, len(components))
 
-	for i, componentData := range components {
-		componentType := reflect.TypeOf(componentData)
-		ctype, ok := esync.Registered(componentType)
+	for _, component := range components {
+		componentType := reflect.TypeOf(component.value)
+		record, ok := esync.LookupRecord(componentType)
 		if !ok {
-			// TODO: Add back erroring here
+			err := &SyncError{
+				Kind:        ErrUnknownComponent,
+				NetworkId:   networkId,
+				ComponentId: component.id,
+				Err:         fmt.Errorf("component %s is not registered with esync", componentType),
+			}
+			if handleError(sender, err) {
+				return false
+			}
 			continue
 		}
 
-		ctypes = append(ctypes, ctype)
-		refTypes[i] = componentType
+		ctypes = append(ctypes, record.Type())
+		records = append(records, record)
+		received = append(received, component)
 	}
 
-	entity := esync.FindByNetworkId(world, networkId)
-	var entry *donburi.Entry
-	if !world.Valid(entity) {
-		entity = world.Create(ctypes...)
+	index := esync.Index(world)
+	entity, ok := index.Lookup(networkId)
+	spawned := !ok
+
+	var prefab Prefab
+	if spawned {
+		prefab, _ = lookupPrefab(received)
+		for _, ctype := range prefab.Components {
+			if !slices.Contains(ctypes, ctype) {
+				ctypes = append(ctypes, ctype)
+			}
+		}
+
+		// The server has reused the index of an entity we still know about,
+		// so that entity is gone and this is a new one.
+		if staleId, stale, found := index.Stale(networkId); found {
+			despawn(world.Entry(stale), staleId)
+		}
+
+		entity = world.Create(append(ctypes, replicaComponent)...)
+		esync.SetNetworkId(world.Entry(entity), networkId)
 	}
 
-	entry = world.Entry(entity)
+	entry := world.Entry(entity)
 	now := time.Now()
 
 	// calculate average latency and the delay index
 	calculateDelay(now)
 
-	if entry != nil && world.Valid(entity) {
-		interpolated := entry.HasComponent(esync.InterpComponent)
+	if entry == nil || !world.Valid(entity) {
+		return true
+	}
 
-		for i := 0; i < len(components); i++ {
-			data := components[i]
-			if data == nil {
-				panic("meow")
-			}
+	if !entry.HasComponent(replicaComponent) {
+		entry.AddComponent(replicaComponent)
+	}
+	replica := replicaComponent.Get(entry)
+	if replica.state == nil {
+		replica.state = make(map[esync.ComponentId][]byte, len(received))
+	}
 
-			ok := esync.RegisteredInterpType(refTypes[i])
-			if !ok || !interpolated {
-				entry.SetComponent(ctypes[i], esync.ComponentFromVal(ctypes[i], data))
-				continue
-			}
+	interpolated := entry.HasComponent(esync.InterpComponent)
+
+	var changed []int
+	for i, record := range records {
+		data := received[i].value
+
+		if previous, ok := replica.state[received[i].id]; !ok || !bytes.Equal(previous, received[i].data) {
+			replica.state[received[i].id] = bytes.Clone(received[i].data)
+			changed = append(changed, i)
+		}
 
-			key := esync.LookupInterpId(refTypes[i])
-			// Add the base value for this component if it doesn't have one
-			if !entry.HasComponent(ctypes[i]) {
-				entry.SetComponent(ctypes[i], ctypes[i].New())
+		key := esync.LookupInterpId(record.Type().Typ())
+		if key == 0 || !interpolated {
+			err := record.SetAny(entry, data)
+			if err != nil {
+				if handleError(sender, &SyncError{Kind: ErrDecode, NetworkId: networkId, ComponentId: record.Id(), Err: err}) {
+					return false
+				}
 			}
+			continue
+		}
+
+		// Add the base value for this component if it doesn't have one
+		if !entry.HasComponent(record.Type()) {
+			entry.AddComponent(record.Type())
+		}
 
-			// Add a component cache to keep track of historic values for this
-			// interpolated component
-			if !entry.HasComponent(timeCacheComponent) {
-				donburi.Add(entry, timeCacheComponent, &timeCacheData{})
+		// Add a component cache to keep track of historic values for this
+		// interpolated component
+		if !entry.HasComponent(timeCacheComponent) {
+			donburi.Add(entry, timeCacheComponent, &timeCacheData{})
+		}
+
+		// Append the new value to our historic cache with its associated
+		// timestamp of when we received this
+		err := timeCacheComponent.Get(entry).getOrCreate(key, record).Push(data, now)
+		if err != nil {
+			if handleError(sender, &SyncError{Kind: ErrDecode, NetworkId: networkId, ComponentId: record.Id(), Err: err}) {
+				return false
 			}
+		}
+	}
+
+	// Replicated components the server no longer sends are removed, client-only components
+	// are never touched. Components that were sent but skipped by the error policy are kept.
+	removeStaleReplicas(entry, replica, ent.State)
+
+	for _, i := range changed {
+		attachSmoothing(entry, received[i].id)
+	}
+
+	if spawned {
+		if prefab.Build != nil {
+			prefab.Build(entry)
+		}
+		fireSpawn(entry, networkId)
+	}
+	for _, i := range changed {
+		fireChanged(entry, networkId, received[i].value)
+	}
 
-			// Append the new value to our historic cache with its associated
-			// timestamp of when we received this
-			multHistory := timeCacheComponent.Get(entry)
-			multHistory.history[key] = append(multHistory.history[key], componentTimeData{
-				value: data,
-				ts:    now,
-			})
-
-			// Shift the positions if we've reached the limit
-			if len(multHistory.history[key]) > MaxHistorySize {
-				multHistory.history[key] = multHistory.history[key][1:]
+	return true
+}
+
+func removeStaleReplicas(entry *donburi.Entry, replica *replicaData, sent esync.EntityState) {
+	for id := range replica.state {
+		if _, ok := sent[id]; ok {
+			continue
+		}
+
+		delete(replica.state, id)
+		removeReplicated(entry, id)
+	}
+}
+
+// removeReplicated removes the replicated component with the given ID from the entry.
+func removeReplicated(entry *donburi.Entry, id esync.ComponentId) {
+	typ := esync.Mapper.Lookup(uint(id))
+	if typ == nil {
+		return
+	}
+
+	record, ok := esync.LookupRecord(typ)
+	if !ok || record.Type() == esync.NetworkIdComponent || !entry.HasComponent(record.Type()) {
+		return
+	}
+
+	entry.RemoveComponent(record.Type())
+}
+
+// despawn handles a networked entity going away according to the despawn mode,
+// after calling the despawn callbacks.
+func despawn(entry *donburi.Entry, id esync.NetworkId) {
+	if !entry.Valid() {
+		return
+	}
+
+	fireDespawn(entry, id)
+
+	// The callbacks might have removed the entity themselves.
+	if !entry.Valid() {
+		return
+	}
+
+	switch despawnMode {
+	case DespawnRemove:
+		entry.Remove()
+	case DespawnStripReplicated:
+		if entry.HasComponent(replicaComponent) {
+			for componentId := range replicaComponent.Get(entry).state {
+				removeReplicated(entry, componentId)
 			}
 		}
+		detach(entry, id)
+	case DespawnKeep:
+		detach(entry, id)
+	}
+}
+
+// detach removes everything esync uses to track the entry as a networked entity.
+func detach(entry *donburi.Entry, id esync.NetworkId) {
+	esync.Index(entry.World).Remove(id)
+
+	for _, ctype := range []donburi.IComponentType{
+		esync.NetworkIdComponent,
+		esync.InterpComponent,
+		replicaComponent,
+		timeCacheComponent,
+		smoothingComponent,
+	} {
+		if entry.HasComponent(ctype) {
+			entry.RemoveComponent(ctype)
+		}
 	}
 }
 
+// RegisterClient registers the snapshot handling for the world on the client.
+// Errors that occur while applying snapshots are passed to the [router.OnError] callbacks
+// and then handled according to the policy set with [SetErrorPolicy].
 func RegisterClient(world donburi.World) {
+	router.On[esync.Despawn](func(sender *router.NetworkClient, message esync.Despawn) {
+		for _, id := range message.Ids {
+			entity, ok := esync.LookupEntity(world, id)
+			if !ok {
+				continue
+			}
+
+			despawn(world.Entry(entity), id)
+		}
+	})
+
 	router.On[esync.WorldSnapshot](func(sender *router.NetworkClient, message esync.WorldSnapshot) {
-		err := clientUpdateWorldState(world, message)
-		if err != nil {
-			panic(err)
-			// TODO: Add back error handling here
+		server.Store(sender)
+
+		if !clientUpdateWorldState(world, sender, message) {
+			return
 		}
 
-		// Removal of old entities
-		esync.NetworkEntityQuery.Each(world, func(entry *donburi.Entry) {
-			id := esync.GetNetworkId(entry)
-			if id == nil {
-				return
-			}
+		removeMissing(world, message)
+	}, router.WithChannel(router.UnreliableSequenced))
+}
 
-			var found bool
-			for _, entity := range message {
-				if entity.Id == *id {
-					found = true
-				}
-			}
+// removeMissing despawns the networked entities that are not part of the snapshot.
+func removeMissing(world donburi.World, snapshot esync.WorldSnapshot) {
+	received := make(map[esync.NetworkId]struct{}, len(snapshot))
+	for _, entity := range snapshot {
+		received[entity.Id] = struct{}{}
+	}
 
-			if !found {
-				entry.Remove()
-			}
-		})
+	var missing []esync.NetworkId
+	esync.NetworkEntityQuery.Each(world, func(entry *donburi.Entry) {
+		id := esync.GetNetworkId(entry)
+		if id == nil {
+			return
+		}
+
+		if _, found := received[*id]; !found {
+			missing = append(missing, *id)
+		}
 	})
+
+	// Entities are removed after the query, so the query is not modified while iterating.
+	for _, id := range missing {
+		entity, ok := esync.LookupEntity(world, id)
+		if !ok {
+			continue
+		}
+
+		despawn(world.Entry(entity), id)
+	}
 }
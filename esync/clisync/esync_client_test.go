@@ -0,0 +1,216 @@
+package clisync
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/leap-fish/necs/esync"
+	"github.com/leap-fish/necs/router"
+	"github.com/stretchr/testify/assert"
+	"github.com/yohamta/donburi"
+)
+
+type Health struct {
+	Current, Max uint8
+}
+
+var HealthComponent = donburi.NewComponentType[Health]()
+
+func init() {
+	_ = esync.RegisterComponent(1, esync.NetworkId(0), esync.NetworkIdComponent)
+	_ = esync.RegisterComponent(200, Health{}, HealthComponent)
+}
+
+func serializedEntity(t *testing.T, id esync.NetworkId, components ...any) esync.SerializedEntity {
+	state := esync.EntityState{}
+	for _, component := range append(components, id) {
+		data, err := esync.Mapper.Serialize(component)
+		assert.Nil(t, err)
+		state[esync.ComponentId(esync.Mapper.LookupId(reflect.TypeOf(component)))] = data
+	}
+
+	return esync.SerializedEntity{Id: id, State: state}
+}
+
+func TestClientUpdateWorldState_ErrorPolicy(t *testing.T) {
+	defer SetErrorPolicy(nil)
+
+	world := donburi.NewWorld()
+	snapshot := esync.WorldSnapshot{
+		serializedEntity(t, 1, Health{Current: 5, Max: 10}),
+		serializedEntity(t, 2, Health{Current: 1, Max: 10}),
+	}
+	snapshot[0].State[999] = []byte{0x01}
+
+	var reported []*SyncError
+	SetErrorPolicy(func(sender *router.NetworkClient, err *SyncError) ErrorAction {
+		reported = append(reported, err)
+		return Skip
+	})
+
+	assert.True(t, clientUpdateWorldState(world, &router.NetworkClient{}, snapshot))
+	assert.Len(t, reported, 1)
+	assert.ErrorIs(t, reported[0], ErrUnknownComponent)
+	assert.Equal(t, esync.NetworkId(1), reported[0].NetworkId)
+	assert.Equal(t, esync.ComponentId(999), reported[0].ComponentId)
+
+	// The rest of the snapshot is still applied when skipping.
+	assert.Equal(t, 2, esync.NetworkEntityQuery.Count(world))
+
+	SetErrorPolicy(DisconnectOnError)
+	snapshot[1].State[esync.ComponentId(200)] = []byte{0xc1}
+	assert.False(t, clientUpdateWorldState(donburi.NewWorld(), &router.NetworkClient{}, snapshot))
+}
+
+func TestApplyEntityDiff_ReusedNetworkId(t *testing.T) {
+	world := donburi.NewWorld()
+
+	first := esync.NewNetworkId(5, 0)
+	assert.True(t, clientUpdateWorldState(world, nil, esync.WorldSnapshot{serializedEntity(t, first, Health{Current: 1})}))
+	old := esync.FindByNetworkId(world, first)
+	assert.True(t, world.Valid(old))
+
+	// The server reused the index with a new generation, so the old entity is replaced.
+	second := esync.NewNetworkId(5, 1)
+	assert.True(t, clientUpdateWorldState(world, nil, esync.WorldSnapshot{serializedEntity(t, second, Health{Current: 9})}))
+	assert.False(t, world.Valid(old))
+	assert.Equal(t, 1, esync.NetworkEntityQuery.Count(world))
+
+	entry := world.Entry(esync.FindByNetworkId(world, second))
+	assert.Equal(t, uint8(9), HealthComponent.Get(entry).Current)
+}
+
+func TestLifecycleCallbacks(t *testing.T) {
+	defer func() {
+		spawnCallbacks = nil
+		despawnCallbacks = nil
+		changeCallbacks = make(map[reflect.Type][]func(entry *donburi.Entry, id esync.NetworkId, value any))
+	}()
+
+	var spawned, despawned []esync.NetworkId
+	var changes []Health
+	OnNetworkSpawn(func(entry *donburi.Entry, id esync.NetworkId) {
+		spawned = append(spawned, id)
+	})
+	OnNetworkDespawn(func(entry *donburi.Entry, id esync.NetworkId) {
+		assert.True(t, entry.Valid())
+		despawned = append(despawned, id)
+	})
+	OnComponentChanged(func(entry *donburi.Entry, id esync.NetworkId, value Health) {
+		changes = append(changes, value)
+	})
+
+	world := donburi.NewWorld()
+	apply := func(snapshot esync.WorldSnapshot) {
+		assert.True(t, clientUpdateWorldState(world, nil, snapshot))
+		removeMissing(world, snapshot)
+	}
+
+	apply(esync.WorldSnapshot{serializedEntity(t, 1, Health{Current: 5})})
+	apply(esync.WorldSnapshot{serializedEntity(t, 1, Health{Current: 5})})
+	apply(esync.WorldSnapshot{serializedEntity(t, 1, Health{Current: 4})})
+	apply(esync.WorldSnapshot{})
+
+	assert.Equal(t, []esync.NetworkId{1}, spawned)
+	assert.Equal(t, []Health{{Current: 5}, {Current: 4}}, changes)
+	assert.Equal(t, []esync.NetworkId{1}, despawned)
+	assert.Equal(t, 0, esync.NetworkEntityQuery.Count(world))
+}
+
+func TestClientUpdateWorldState_SkippedComponentIsKept(t *testing.T) {
+	defer SetErrorPolicy(nil)
+	SetErrorPolicy(func(sender *router.NetworkClient, err *SyncError) ErrorAction {
+		return Skip
+	})
+
+	world := donburi.NewWorld()
+	id := esync.NetworkId(5)
+	assert.True(t, clientUpdateWorldState(world, nil, esync.WorldSnapshot{serializedEntity(t, id, Health{Current: 5})}))
+	entry := world.Entry(esync.FindByNetworkId(world, id))
+
+	// The component is still sent, it just could not be decoded this time.
+	snapshot := esync.WorldSnapshot{serializedEntity(t, id, Health{Current: 1})}
+	snapshot[0].State[esync.ComponentId(200)] = []byte{0xc1}
+	assert.True(t, clientUpdateWorldState(world, nil, snapshot))
+	assert.True(t, entry.HasComponent(HealthComponent))
+	assert.Equal(t, uint8(5), HealthComponent.Get(entry).Current)
+}
+
+type Sprite struct {
+	Name string
+}
+
+var SpriteComponent = donburi.NewComponentType[Sprite]()
+
+func TestDespawnMode_PreservesClientComponents(t *testing.T) {
+	defer SetDespawnMode(DespawnRemove)
+
+	world := donburi.NewWorld()
+	id := esync.NetworkId(3)
+	snapshot := esync.WorldSnapshot{serializedEntity(t, id, Health{Current: 5})}
+	assert.True(t, clientUpdateWorldState(world, nil, snapshot))
+
+	entity := esync.FindByNetworkId(world, id)
+	entry := world.Entry(entity)
+	donburi.Add(entry, SpriteComponent, &Sprite{Name: "fish"})
+
+	// Updates only touch replicated components.
+	snapshot = esync.WorldSnapshot{serializedEntity(t, id, Health{Current: 1})}
+	assert.True(t, clientUpdateWorldState(world, nil, snapshot))
+	assert.Equal(t, "fish", SpriteComponent.Get(entry).Name)
+	assert.Equal(t, uint8(1), HealthComponent.Get(entry).Current)
+
+	// Components the server stops sending are removed, client-only ones are kept.
+	assert.True(t, clientUpdateWorldState(world, nil, esync.WorldSnapshot{serializedEntity(t, id)}))
+	assert.False(t, entry.HasComponent(HealthComponent))
+	assert.True(t, entry.HasComponent(SpriteComponent))
+
+	SetDespawnMode(DespawnStripReplicated)
+	assert.True(t, clientUpdateWorldState(world, nil, esync.WorldSnapshot{serializedEntity(t, id, Health{Current: 1})}))
+	removeMissing(world, esync.WorldSnapshot{})
+
+	assert.True(t, world.Valid(entity))
+	assert.False(t, entry.HasComponent(esync.NetworkIdComponent))
+	assert.False(t, entry.HasComponent(HealthComponent))
+	assert.Equal(t, "fish", SpriteComponent.Get(entry).Name)
+	assert.Equal(t, 0, esync.Index(world).Len())
+
+	SetDespawnMode(DespawnKeep)
+	assert.True(t, clientUpdateWorldState(world, nil, esync.WorldSnapshot{serializedEntity(t, 4, Health{Current: 2})}))
+	kept := esync.FindByNetworkId(world, 4)
+	removeMissing(world, esync.WorldSnapshot{})
+	assert.True(t, world.Valid(kept))
+	assert.Equal(t, uint8(2), HealthComponent.Get(world.Entry(kept)).Current)
+	assert.Equal(t, 0, esync.NetworkEntityQuery.Count(world))
+}
+
+type Kind uint8
+
+var KindComponent = donburi.NewComponentType[Kind]()
+
+func TestRegisterPrefab(t *testing.T) {
+	defer func() { prefabs = make(map[reflect.Type]map[any]Prefab) }()
+	assert.Nil(t, esync.RegisterComponent(201, Kind(0), KindComponent))
+
+	var built []esync.NetworkId
+	RegisterPrefab(Kind(2), Prefab{
+		Components: []donburi.IComponentType{SpriteComponent},
+		Build: func(entry *donburi.Entry) {
+			assert.Equal(t, uint8(7), HealthComponent.Get(entry).Current)
+			SpriteComponent.SetValue(entry, Sprite{Name: "projectile"})
+			built = append(built, *esync.GetNetworkId(entry))
+		},
+	})
+
+	world := donburi.NewWorld()
+	snapshot := esync.WorldSnapshot{
+		serializedEntity(t, 1, Kind(1), Health{Current: 7}),
+		serializedEntity(t, 2, Kind(2), Health{Current: 7}),
+	}
+	assert.True(t, clientUpdateWorldState(world, nil, snapshot))
+	assert.True(t, clientUpdateWorldState(world, nil, snapshot))
+
+	assert.Equal(t, []esync.NetworkId{2}, built)
+	assert.False(t, world.Entry(esync.FindByNetworkId(world, 1)).HasComponent(SpriteComponent))
+	assert.Equal(t, "projectile", SpriteComponent.Get(world.Entry(esync.FindByNetworkId(world, 2))).Name)
+}
@@ -1,11 +1,8 @@
 package clisync
 
 import (
-	"fmt"
 	"math"
-	"reflect"
 	"time"
-	"unsafe"
 
 	"github.com/leap-fish/necs/esync"
 	"github.com/yohamta/donburi"
@@ -17,16 +14,51 @@ var (
 	timeCacheComponent = donburi.NewComponentType[timeCacheData]()
 )
 
-type componentTimeData struct {
-	value any
-	ts    time.Time
+// DefaultHistorySize is the number of samples kept for each interpolated component of an entity.
+const DefaultHistorySize = 32
+
+var historySize = DefaultHistorySize
+
+// SetHistorySize sets the number of samples kept for each interpolated component of an entity.
+// It only applies to histories created afterwards, so it should be called before connecting.
+// Interpolating needs at least two samples, smaller sizes are raised to 2.
+func SetHistorySize(size int) {
+	historySize = max(size, 2)
+}
+
+// componentHistory is the history of a single interpolated component.
+type componentHistory struct {
+	key     uint8
+	history esync.History
 }
 
-// timeCacheData contains a map which the key matches an interpolation
-// component key and contains a list of historic values for that type.
+// timeCacheData keeps the received values of the components an entity interpolates,
+// it only holds a history for the components that are actually interpolated.
 type timeCacheData struct {
-	// map[component key]historic values
-	history [math.MaxUint8][]componentTimeData
+	histories []componentHistory
+}
+
+// get returns the history of the interpolated component with the given key.
+// Entities only interpolate a handful of components, so a linear search is the fastest.
+func (d *timeCacheData) get(key uint8) esync.History {
+	for i := range d.histories {
+		if d.histories[i].key == key {
+			return d.histories[i].history
+		}
+	}
+	return nil
+}
+
+// getOrCreate returns the history of the interpolated component with the given key,
+// creating it if the entity does not have one yet.
+func (d *timeCacheData) getOrCreate(key uint8, record esync.Record) esync.History {
+	if history := d.get(key); history != nil {
+		return history
+	}
+
+	history := record.NewHistory(historySize)
+	d.histories = append(d.histories, componentHistory{key: key, history: history})
+	return history
 }
 
 var (
@@ -48,6 +80,24 @@ func calculateDelay(now time.Time) {
 	lastSnapshot = now
 }
 
+// reportedKeys keeps track of the unknown interpolation keys an interpolation system has
+// reported, so the error is not reported again every frame.
+type reportedKeys [math.MaxUint8 + 1]bool
+
+func (r *reportedKeys) report(entry *donburi.Entry, key uint8) {
+	if r[key] {
+		return
+	}
+	r[key] = true
+
+	var networkId esync.NetworkId
+	if id := esync.GetNetworkId(entry); id != nil {
+		networkId = *id
+	}
+
+	handleError(server.Load(), &SyncError{Kind: ErrUnknownInterpKey, NetworkId: networkId, InterpKey: key})
+}
+
 // NewInterpolateSystem returns an ecs system that should be registered if you
 // have any client-side interpolating components.
 func NewInterpolateSystem() ecs.System {
@@ -56,6 +106,7 @@ func NewInterpolateSystem() ecs.System {
 		esync.InterpComponent,
 		timeCacheComponent,
 	))
+	var reported reportedKeys
 
 	return func(ecs *ecs.ECS) {
 		now := time.Now()
@@ -71,67 +122,53 @@ func NewInterpolateSystem() ecs.System {
 			// Loop through each of this entry's interpolated components and
 			// interpolate them using their lerp functions.
 			for _, key := range interpolated.ComponentKeys() {
-				compType := esync.LookupInterpType(key)
-				comp, ok := esync.Registered(compType)
+				record, ok := esync.LookupInterpRecord(key)
 				if !ok {
-					panic(fmt.Sprintf("unregistered component %T", compType))
+					reported.report(e, key)
+					continue
 				}
-				if !e.HasComponent(comp) {
+				if !e.HasComponent(record.Type()) {
 					continue
 				}
 
-				var (
-					prev, next, delayed *componentTimeData
-				)
-
 				// Get the historic buffer for this component type.
-				buf := multiHistory.history[key]
-				if len(buf) <= 1 {
+				buf := multiHistory.get(key)
+				if buf == nil || buf.Len() <= 1 {
 					continue // to fix a rare panic we skip this
 				}
-				for i := len(buf) - 1; i >= 0; i-- {
-					if buf[i].ts.Compare(now) <= 0 {
-						if len(buf) <= i {
-							continue
-						}
 
-						prev = &buf[i]
+				prev, next := -1, -1
+				for i := buf.Len() - 1; i >= 0; i-- {
+					if buf.Timestamp(i).Compare(now) <= 0 {
+						prev = i
 
 						if i > 0 {
-							next = &buf[i-1]
+							next = i - 1
 							break
 						}
 					}
 				}
 				// delayed should be our latest component value given our average
 				// latency delay (in seconds).
-				delayed = &buf[max(0, len(buf)-1-delay)]
+				delayed := max(0, buf.Len()-1-delay)
 
-				if prev == nil {
-					e.SetComponent(comp, unsafe.Pointer(&buf[0].value))
+				if prev < 0 {
+					buf.Apply(e, 0)
 					continue
 				}
-				if next == nil {
-					e.SetComponent(comp, unsafe.Pointer(&buf[len(buf)-1].value))
+				if next < 0 {
+					buf.Apply(e, buf.Len()-1)
 					continue
 				}
 
 				// Get the `t` value for our lerp function by getting the difference in
 				// our prev position and average it by our average latency's position
 				// compared to our next position.
-				t := float64(now.Sub(prev.ts)) / float64(delayed.ts.Sub(next.ts))
-
-				setter := esync.LookupInterpSetter(key)
-				values := setter.Call([]reflect.Value{
-					reflect.ValueOf(next.value),
-					reflect.ValueOf(delayed.value),
-					reflect.ValueOf(t),
-				})
-
-				// Return value from the setter should be the interpolated value
-				// now set the component.
-				got := values[0].UnsafePointer()
-				e.SetComponent(comp, got)
+				t := float64(now.Sub(buf.Timestamp(prev))) / float64(buf.Timestamp(delayed).Sub(buf.Timestamp(next)))
+
+				// The lerp function is called typed through the history buffer,
+				// which sets the component to the interpolated value.
+				buf.Lerp(e, next, delayed, t)
 			}
 		}
 	}
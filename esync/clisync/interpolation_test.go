@@ -0,0 +1,101 @@
+package clisync
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/leap-fish/necs/esync"
+	"github.com/leap-fish/necs/router"
+	"github.com/stretchr/testify/assert"
+	"github.com/yohamta/donburi"
+	"github.com/yohamta/donburi/ecs"
+)
+
+type Position struct {
+	X, Y float64
+}
+
+var PositionComponent = donburi.NewComponentType[Position]()
+
+func lerpPosition(from, to Position, delta float64) Position {
+	return Position{
+		X: from.X + (to.X-from.X)*delta,
+		Y: from.Y + (to.Y-from.Y)*delta,
+	}
+}
+
+func init() {
+	_ = esync.RegisterComponent(202, Position{}, PositionComponent, esync.WithLerpFn(1, lerpPosition))
+}
+
+func newInterpolatedWorld(t testing.TB, count int) *ecs.ECS {
+	world := donburi.NewWorld()
+	now := time.Now()
+
+	for i := 0; i < count; i++ {
+		entry := world.Entry(world.Create(PositionComponent, esync.InterpComponent, timeCacheComponent))
+		esync.SetNetworkId(entry, esync.NetworkId(i+1))
+		esync.InterpComponent.Set(entry, esync.NewInterpData(PositionComponent))
+
+		record, _ := esync.LookupInterpRecord(1)
+		history := timeCacheComponent.Get(entry).getOrCreate(1, record)
+		for j := 3; j > 0; j-- {
+			assert.Nil(t, history.Push(Position{X: float64(j)}, now.Add(-time.Duration(j)*time.Second)))
+		}
+	}
+
+	return ecs.NewECS(world)
+}
+
+func TestInterpolateSystem_UnknownKey(t *testing.T) {
+	defer SetErrorPolicy(nil)
+
+	var reported []*SyncError
+	SetErrorPolicy(func(sender *router.NetworkClient, err *SyncError) ErrorAction {
+		reported = append(reported, err)
+		return Skip
+	})
+
+	// The highest key the wire format allows is reported instead of indexing out of range.
+	world := donburi.NewWorld()
+	entry := world.Entry(world.Create(PositionComponent, esync.InterpComponent, timeCacheComponent))
+	esync.SetNetworkId(entry, 7)
+	esync.InterpComponent.Set(entry, &esync.InterpData{Components: []uint8{math.MaxUint8}})
+
+	system := NewInterpolateSystem()
+	assert.NotPanics(t, func() { system(ecs.NewECS(world)) })
+	assert.Len(t, reported, 1)
+	assert.ErrorIs(t, reported[0], ErrUnknownInterpKey)
+	assert.Equal(t, uint8(math.MaxUint8), reported[0].InterpKey)
+
+	// The error is reported once per system, not every frame.
+	system(ecs.NewECS(world))
+	assert.Len(t, reported, 1)
+	NewInterpolateSystem()(ecs.NewECS(world))
+	assert.Len(t, reported, 2)
+}
+
+func TestInterpolateSystem_DoesNotAllocate(t *testing.T) {
+	system := NewInterpolateSystem()
+	world := newInterpolatedWorld(t, 10_000)
+
+	system(world)
+	allocs := testing.AllocsPerRun(10, func() {
+		system(world)
+	})
+
+	// Only the query iterator may allocate, never anything per entity.
+	assert.LessOrEqual(t, allocs, float64(10))
+}
+
+func BenchmarkInterpolateSystem(b *testing.B) {
+	system := NewInterpolateSystem()
+	world := newInterpolatedWorld(b, 10_000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		system(world)
+	}
+}
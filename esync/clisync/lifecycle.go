@@ -0,0 +1,62 @@
+package clisync
+
+import (
+	"reflect"
+
+	"github.com/leap-fish/necs/esync"
+	"github.com/yohamta/donburi"
+)
+
+// Lifecycle callbacks are called synchronously while a snapshot is applied, so the entry is
+// always valid inside of them. They should not block.
+var (
+	spawnCallbacks   []func(entry *donburi.Entry, id esync.NetworkId)
+	despawnCallbacks []func(entry *donburi.Entry, id esync.NetworkId)
+	changeCallbacks  = make(map[reflect.Type][]func(entry *donburi.Entry, id esync.NetworkId, value any))
+)
+
+// OnNetworkSpawn adds a callback to call whenever a networked entity is created on the client.
+// It is called after all the components of the first snapshot have been applied.
+func OnNetworkSpawn(callback func(entry *donburi.Entry, id esync.NetworkId)) {
+	spawnCallbacks = append(spawnCallbacks, callback)
+}
+
+// OnNetworkDespawn adds a callback to call whenever a networked entity goes away, either because
+// the server despawned it or because it is no longer part of the snapshots.
+// It is called before the entity is removed, so all of its components can still be accessed.
+func OnNetworkDespawn(callback func(entry *donburi.Entry, id esync.NetworkId)) {
+	despawnCallbacks = append(despawnCallbacks, callback)
+}
+
+// OnComponentChanged adds a callback to call whenever the replicated component T of a networked
+// entity changes. This includes the first value received when the entity spawns.
+//
+// For interpolated components the value is the new authoritative value received from the
+// server, not the interpolated value.
+func OnComponentChanged[T any](callback func(entry *donburi.Entry, id esync.NetworkId, value T)) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	changeCallbacks[typ] = append(changeCallbacks[typ], func(entry *donburi.Entry, id esync.NetworkId, value any) {
+		callback(entry, id, value.(T))
+	})
+}
+
+func fireSpawn(entry *donburi.Entry, id esync.NetworkId) {
+	for _, callback := range spawnCallbacks {
+		callback(entry, id)
+	}
+}
+
+func fireDespawn(entry *donburi.Entry, id esync.NetworkId) {
+	for _, callback := range despawnCallbacks {
+		callback(entry, id)
+	}
+}
+
+func fireChanged(entry *donburi.Entry, id esync.NetworkId, value any) {
+	for _, callback := range changeCallbacks[reflect.TypeOf(value)] {
+		if !entry.Valid() {
+			return
+		}
+		callback(entry, id, value)
+	}
+}
@@ -0,0 +1,73 @@
+package clisync
+
+import (
+	"reflect"
+
+	"github.com/yohamta/donburi"
+)
+
+// Prefab describes the client-only parts of a networked entity of a certain kind.
+type Prefab struct {
+	// Components are added to the entity when it is created, alongside its replicated components.
+	Components []donburi.IComponentType
+	// Build is called once the entity has been created and its replicated components have been
+	// applied, before the [OnNetworkSpawn] callbacks. It can be used to initialize the components.
+	Build func(entry *donburi.Entry)
+}
+
+// prefabs maps the type of an archetype component to the prefabs keyed by its value.
+var prefabs = make(map[reflect.Type]map[any]Prefab)
+
+// RegisterPrefab registers a prefab for networked entities whose replicated archetype component
+// has the given value. The archetype component can be any registered component type
+// with a comparable value, for example:
+//
+//	type Kind uint8
+//
+//	const (
+//		KindPlayer Kind = iota
+//		KindProjectile
+//	)
+//
+//	var KindComponent = donburi.NewComponentType[Kind]()
+//
+//	esync.RegisterComponent(20, Kind(0), KindComponent)
+//
+//	clisync.RegisterPrefab(KindProjectile, clisync.Prefab{
+//		Components: []donburi.IComponentType{SpriteComponent, TrailComponent},
+//		Build: func(entry *donburi.Entry) {
+//			SpriteComponent.SetValue(entry, projectileSprite)
+//		},
+//	})
+//
+// An entity should only have a single archetype component, if its components match several
+// prefabs only one of them is used.
+func RegisterPrefab[T comparable](key T, prefab Prefab) {
+	typ := reflect.TypeOf(key)
+	if prefabs[typ] == nil {
+		prefabs[typ] = make(map[any]Prefab)
+	}
+
+	prefabs[typ][key] = prefab
+}
+
+// lookupPrefab finds the prefab matching one of the received components.
+func lookupPrefab(components []receivedComponent) (Prefab, bool) {
+	if len(prefabs) == 0 {
+		return Prefab{}, false
+	}
+
+	for _, component := range components {
+		byKey, ok := prefabs[reflect.TypeOf(component.value)]
+		if !ok {
+			continue
+		}
+
+		prefab, ok := byKey[component.value]
+		if ok {
+			return prefab, true
+		}
+	}
+
+	return Prefab{}, false
+}
@@ -0,0 +1,182 @@
+package clisync
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/leap-fish/necs/esync"
+	"github.com/yohamta/donburi"
+	"github.com/yohamta/donburi/ecs"
+	"github.com/yohamta/donburi/filter"
+)
+
+// Smoothing configures the visual smoothing of the component T, see [EnableSmoothing].
+type Smoothing[T any] struct {
+	// Duration is how long it takes for most of the error between the rendered and the
+	// authoritative value to disappear. The error decays exponentially, after Duration about
+	// 63% of it is gone. A zero duration always snaps.
+	Duration time.Duration
+	// Lerp is used to move the rendered value towards the authoritative value. It defaults to
+	// the lerp function registered with [esync.WithLerpFn].
+	Lerp esync.InterpolateFn[T]
+	// Distance is used to measure the error, it defaults to the distance function registered
+	// with [esync.WithSnapThreshold]. Without a distance function the rendered value never snaps.
+	Distance esync.DistanceFn[T]
+	// SnapDistance is the error beyond which the rendered value snaps to the authoritative value.
+	// It defaults to the limit registered with [esync.WithSnapThreshold], zero never snaps.
+	SnapDistance float64
+}
+
+// smoothed is the type-erased rendered value of a smoothed component of an entity.
+type smoothed interface {
+	id() esync.ComponentId
+	update(entry *donburi.Entry, dt time.Duration)
+}
+
+type smoothedValue[T any] struct {
+	record      *esync.ComponentRecord[T]
+	config      *Smoothing[T]
+	rendered    T
+	initialized bool
+}
+
+func (s *smoothedValue[T]) id() esync.ComponentId {
+	return s.record.Id()
+}
+
+func (s *smoothedValue[T]) update(entry *donburi.Entry, dt time.Duration) {
+	target, ok := s.record.Get(entry)
+	if !ok {
+		return
+	}
+
+	if !s.initialized || s.config.Duration <= 0 {
+		s.rendered = target
+		s.initialized = true
+		return
+	}
+
+	if s.config.Distance != nil && s.config.SnapDistance > 0 && s.config.Distance(s.rendered, target) > s.config.SnapDistance {
+		s.rendered = target
+		return
+	}
+
+	// Exponential decay keeps the smoothing independent of the frame rate.
+	alpha := 1 - math.Exp(-dt.Seconds()/s.config.Duration.Seconds())
+	s.rendered = s.config.Lerp(s.rendered, target, alpha)
+}
+
+// smoothingComponent holds the rendered values of the smoothed components of an entity.
+var smoothingComponent = donburi.NewComponentType[smoothingData]()
+
+type smoothingData struct {
+	values []smoothed
+}
+
+func (d *smoothingData) get(id esync.ComponentId) smoothed {
+	for _, value := range d.values {
+		if value.id() == id {
+			return value
+		}
+	}
+	return nil
+}
+
+// smoothers creates the rendered values for the components smoothing is enabled for.
+var smoothers = map[esync.ComponentId]func() smoothed{}
+
+// EnableSmoothing enables visual smoothing for the component T, which must be registered
+// with esync. Instead of jumping to a corrected value, a separate rendered value is kept
+// that moves towards the authoritative value of the component over time.
+//
+// The authoritative value is whatever the component holds, so this works for interpolated
+// components as well as components written by client-side prediction. Render using
+// [RenderedValue] and register the system returned by [NewSmoothingSystem], after the
+// interpolation and prediction systems.
+func EnableSmoothing[T any](config Smoothing[T]) error {
+	record, ok := esync.LookupComponent[T]()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownComponent, reflect.TypeOf((*T)(nil)).Elem())
+	}
+
+	if config.Lerp == nil {
+		config.Lerp = record.Lerp
+	}
+	if config.Lerp == nil {
+		return fmt.Errorf("%w: %s", esync.ErrNoLerpFn, record.ComponentType().Typ())
+	}
+	if config.Distance == nil {
+		config.Distance = record.Distance
+	}
+	if config.SnapDistance == 0 {
+		config.SnapDistance = record.SnapLimit
+	}
+
+	smoothers[record.Id()] = func() smoothed {
+		return &smoothedValue[T]{record: record, config: &config}
+	}
+	return nil
+}
+
+// RenderedValue returns the smoothed value of the component T of the entry. If smoothing is
+// not enabled for T, or the entry has not been smoothed yet, the component value is returned.
+func RenderedValue[T any](entry *donburi.Entry) (T, bool) {
+	record, ok := esync.LookupComponent[T]()
+	if !ok {
+		var zero T
+		return zero, false
+	}
+
+	if entry.Valid() && entry.HasComponent(smoothingComponent) {
+		if value, ok := smoothingComponent.Get(entry).get(record.Id()).(*smoothedValue[T]); ok && value.initialized {
+			return value.rendered, true
+		}
+	}
+
+	return record.Get(entry)
+}
+
+// attachSmoothing gives the entry a rendered value for the component, if smoothing is enabled for it.
+func attachSmoothing(entry *donburi.Entry, id esync.ComponentId) {
+	create, ok := smoothers[id]
+	if !ok {
+		return
+	}
+
+	if !entry.HasComponent(smoothingComponent) {
+		donburi.Add(entry, smoothingComponent, &smoothingData{})
+	}
+
+	data := smoothingComponent.Get(entry)
+	if data.get(id) == nil {
+		data.values = append(data.values, create())
+	}
+}
+
+// NewSmoothingSystem returns an ecs system that moves the rendered values of smoothed components
+// towards their authoritative values, see [EnableSmoothing].
+func NewSmoothingSystem() ecs.System {
+	query := donburi.NewQuery(filter.Contains(smoothingComponent))
+	var last time.Time
+
+	return func(ecs *ecs.ECS) {
+		now := time.Now()
+		var dt time.Duration
+		if !last.IsZero() {
+			dt = now.Sub(last)
+		}
+		last = now
+
+		updateSmoothing(ecs.World, query, dt)
+	}
+}
+
+func updateSmoothing(world donburi.World, query *donburi.Query, dt time.Duration) {
+	for entry := range query.Iter(world) {
+		for _, value := range smoothingComponent.Get(entry).values {
+			value.update(entry, dt)
+		}
+	}
+}
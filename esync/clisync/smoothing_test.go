@@ -0,0 +1,107 @@
+package clisync
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/leap-fish/necs/esync"
+	"github.com/stretchr/testify/assert"
+	"github.com/yohamta/donburi"
+	"github.com/yohamta/donburi/filter"
+)
+
+func distancePosition(from, to Position) float64 {
+	return math.Hypot(to.X-from.X, to.Y-from.Y)
+}
+
+func TestSmoothing(t *testing.T) {
+	defer clear(smoothers)
+
+	err := EnableSmoothing(Smoothing[Position]{
+		Duration:     100 * time.Millisecond,
+		Distance:     distancePosition,
+		SnapDistance: 50,
+	})
+	assert.Nil(t, err)
+
+	world := donburi.NewWorld()
+	query := donburi.NewQuery(filter.Contains(smoothingComponent))
+	entry := world.Entry(world.Create(PositionComponent))
+	attachSmoothing(entry, 202)
+
+	updateSmoothing(world, query, 0)
+	rendered, ok := RenderedValue[Position](entry)
+	assert.True(t, ok)
+	assert.Equal(t, Position{}, rendered)
+
+	// A correction is not applied at once, the error decays over time.
+	PositionComponent.SetValue(entry, Position{X: 10})
+	updateSmoothing(world, query, 100*time.Millisecond)
+	rendered, _ = RenderedValue[Position](entry)
+	assert.InDelta(t, 10*(1-math.Exp(-1)), rendered.X, 1e-9)
+
+	updateSmoothing(world, query, time.Second)
+	rendered, _ = RenderedValue[Position](entry)
+	assert.InDelta(t, 10, rendered.X, 1e-3)
+
+	// Large errors snap.
+	PositionComponent.SetValue(entry, Position{X: 500})
+	updateSmoothing(world, query, time.Millisecond)
+	rendered, _ = RenderedValue[Position](entry)
+	assert.Equal(t, Position{X: 500}, rendered)
+}
+
+type Offset struct {
+	X float64
+}
+
+var OffsetComponent = donburi.NewComponentType[Offset]()
+
+func init() {
+	_ = esync.RegisterComponent(203, Offset{}, OffsetComponent,
+		esync.WithLerpFn(2, func(from, to Offset, delta float64) Offset {
+			return Offset{X: from.X + (to.X-from.X)*delta}
+		}),
+		esync.WithSnapThreshold(func(from, to Offset) float64 { return math.Abs(to.X - from.X) }, 50),
+	)
+}
+
+func TestSmoothing_RegisteredSnapThreshold(t *testing.T) {
+	defer clear(smoothers)
+
+	// The distance function and the snap limit are taken from the registration.
+	assert.Nil(t, EnableSmoothing(Smoothing[Offset]{Duration: 100 * time.Millisecond}))
+
+	world := donburi.NewWorld()
+	query := donburi.NewQuery(filter.Contains(smoothingComponent))
+	entry := world.Entry(world.Create(OffsetComponent))
+	attachSmoothing(entry, 203)
+	updateSmoothing(world, query, 0)
+
+	// An error below the snap limit is smoothed.
+	OffsetComponent.SetValue(entry, Offset{X: 10})
+	updateSmoothing(world, query, 100*time.Millisecond)
+	rendered, _ := RenderedValue[Offset](entry)
+	assert.InDelta(t, 10*(1-math.Exp(-1)), rendered.X, 1e-9)
+
+	OffsetComponent.SetValue(entry, Offset{X: 500})
+	updateSmoothing(world, query, time.Millisecond)
+	rendered, _ = RenderedValue[Offset](entry)
+	assert.Equal(t, Offset{X: 500}, rendered)
+}
+
+func TestRenderedValue_WithoutSmoothing(t *testing.T) {
+	world := donburi.NewWorld()
+	entry := world.Entry(world.Create(PositionComponent))
+	PositionComponent.SetValue(entry, Position{X: 3})
+
+	rendered, ok := RenderedValue[Position](entry)
+	assert.True(t, ok)
+	assert.Equal(t, Position{X: 3}, rendered)
+}
+
+func TestEnableSmoothing_NoLerpFn(t *testing.T) {
+	err := EnableSmoothing(Smoothing[Health]{Duration: time.Second})
+	assert.ErrorIs(t, err, esync.ErrNoLerpFn)
+}
@@ -0,0 +1,192 @@
+package esync
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/yohamta/donburi"
+)
+
+var (
+	ErrComponentType = errors.New("value does not match component type")
+	ErrNoLerpFn      = errors.New("component has no lerp function")
+	ErrNoDistanceFn  = errors.New("component has no distance function")
+)
+
+// Record is the type-erased view of a [ComponentRecord], used where the component type
+// is only known at runtime, such as when applying snapshots.
+type Record interface {
+	// Id returns the ID the component was registered with.
+	Id() ComponentId
+	// Type returns the donburi component type.
+	Type() donburi.IComponentType
+	// Interpolated returns true if the component has a lerp function.
+	Interpolated() bool
+
+	// GetAny returns the component value of the entry, if it has one.
+	GetAny(entry *donburi.Entry) (any, bool)
+	// SetAny writes the value to the entry, adding the component if it is missing.
+	SetAny(entry *donburi.Entry, value any) error
+	// LerpAny interpolates between two values of the component using its lerp function.
+	LerpAny(from, to any, delta float64) (any, error)
+	// EncodeAny serializes a value of the component.
+	EncodeAny(value any) ([]byte, error)
+	// DecodeAny deserializes a value of the component.
+	DecodeAny(data []byte) (any, error)
+	// NewHistory creates a buffer for received values of the component, used for interpolation.
+	NewHistory(limit int) History
+}
+
+// ComponentRecord holds everything esync knows about a registered component type T.
+// Values are always written through the typed component type, so a value can never
+// be written into the memory of a different component.
+type ComponentRecord[T any] struct {
+	id    ComponentId
+	ctype *donburi.ComponentType[T]
+	// interpKey is the interpolation ID given to WithLerpFn.
+	interpKey uint8
+
+	// Lerp is the function used for client-side interpolation, see [WithLerpFn].
+	Lerp InterpolateFn[T]
+	// Cubic is used instead of Lerp when set, see [WithCubicFn].
+	Cubic CubicFn[T]
+	// Distance and SnapLimit are used to snap instead of interpolating, see [WithSnapThreshold].
+	Distance  DistanceFn[T]
+	SnapLimit float64
+}
+
+func (r *ComponentRecord[T]) Id() ComponentId {
+	return r.id
+}
+
+func (r *ComponentRecord[T]) Type() donburi.IComponentType {
+	return r.ctype
+}
+
+// ComponentType returns the typed donburi component type.
+func (r *ComponentRecord[T]) ComponentType() *donburi.ComponentType[T] {
+	return r.ctype
+}
+
+func (r *ComponentRecord[T]) Interpolated() bool {
+	return r.Lerp != nil
+}
+
+// setter returns the lerp function as a [LerpFn], for [LookupInterpSetter].
+func (r *ComponentRecord[T]) setter() LerpFn[T] {
+	lerp := r.Lerp
+	return func(from T, to T, delta float64) *T {
+		value := lerp(from, to, delta)
+		return &value
+	}
+}
+
+// Get returns the component value of the entry, if it has one.
+func (r *ComponentRecord[T]) Get(entry *donburi.Entry) (T, bool) {
+	if !entry.Valid() || !entry.HasComponent(r.ctype) {
+		var zero T
+		return zero, false
+	}
+
+	return r.ctype.GetValue(entry), true
+}
+
+// Set writes the value to the entry, adding the component if it is missing.
+func (r *ComponentRecord[T]) Set(entry *donburi.Entry, value T) {
+	if !entry.HasComponent(r.ctype) {
+		r.add(entry, value)
+		return
+	}
+
+	r.ctype.SetValue(entry, value)
+}
+
+// add is kept out of Set, so the value only escapes to the heap when the component is added.
+func (r *ComponentRecord[T]) add(entry *donburi.Entry, value T) {
+	donburi.Add(entry, r.ctype, &value)
+}
+
+// Encode serializes a value of the component using the esync [Mapper].
+func (r *ComponentRecord[T]) Encode(value T) ([]byte, error) {
+	return Mapper.Serialize(value)
+}
+
+// Decode deserializes a value of the component using the esync [Mapper].
+func (r *ComponentRecord[T]) Decode(data []byte) (T, error) {
+	var zero T
+
+	value, err := Mapper.Deserialize(data)
+	if err != nil {
+		return zero, err
+	}
+
+	typed, ok := value.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: expected %s, got %T", ErrComponentType, r.ctype.Typ(), value)
+	}
+
+	return typed, nil
+}
+
+func (r *ComponentRecord[T]) GetAny(entry *donburi.Entry) (any, bool) {
+	return r.Get(entry)
+}
+
+func (r *ComponentRecord[T]) SetAny(entry *donburi.Entry, value any) error {
+	typed, ok := value.(T)
+	if !ok {
+		return fmt.Errorf("%w: expected %s, got %T", ErrComponentType, r.ctype.Typ(), value)
+	}
+
+	r.Set(entry, typed)
+	return nil
+}
+
+func (r *ComponentRecord[T]) LerpAny(from, to any, delta float64) (any, error) {
+	if r.Lerp == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoLerpFn, r.ctype.Typ())
+	}
+
+	typedFrom, ok := from.(T)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected %s, got %T", ErrComponentType, r.ctype.Typ(), from)
+	}
+
+	typedTo, ok := to.(T)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected %s, got %T", ErrComponentType, r.ctype.Typ(), to)
+	}
+
+	return r.Lerp(typedFrom, typedTo, delta), nil
+}
+
+func (r *ComponentRecord[T]) EncodeAny(value any) ([]byte, error) {
+	typed, ok := value.(T)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected %s, got %T", ErrComponentType, r.ctype.Typ(), value)
+	}
+
+	return r.Encode(typed)
+}
+
+func (r *ComponentRecord[T]) DecodeAny(data []byte) (any, error) {
+	return r.Decode(data)
+}
+
+// LookupRecord returns the registration record of the given component type.
+func LookupRecord(typ reflect.Type) (Record, bool) {
+	record, ok := records[typ]
+	return record, ok
+}
+
+// LookupComponent returns the typed registration record of the component type T.
+func LookupComponent[T any]() (*ComponentRecord[T], bool) {
+	record, ok := records[reflect.TypeOf((*T)(nil)).Elem()]
+	if !ok {
+		return nil, false
+	}
+
+	typed, ok := record.(*ComponentRecord[T])
+	return typed, ok
+}
@@ -0,0 +1,202 @@
+package esync_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leap-fish/necs/esync"
+	"github.com/leap-fish/necs/typemapper"
+	"github.com/stretchr/testify/assert"
+	"github.com/yohamta/donburi"
+)
+
+type Position struct {
+	X, Y float64
+}
+
+type Velocity struct {
+	X, Y float64
+}
+
+var (
+	PositionComponent = donburi.NewComponentType[Position]()
+	VelocityComponent = donburi.NewComponentType[Velocity]()
+)
+
+func lerpPosition(from, to Position, delta float64) Position {
+	return Position{
+		X: from.X + (to.X-from.X)*delta,
+		Y: from.Y + (to.Y-from.Y)*delta,
+	}
+}
+
+func init() {
+	_ = esync.RegisterComponent(100, Position{}, PositionComponent, esync.WithLerpFn(100, lerpPosition))
+	_ = esync.RegisterComponent(101, Velocity{}, VelocityComponent)
+}
+
+func TestComponentRecord_SetAny(t *testing.T) {
+	world := donburi.NewWorld()
+	entry := world.Entry(world.Create(VelocityComponent))
+
+	record, ok := esync.LookupComponent[Position]()
+	assert.True(t, ok)
+
+	// The component is added when the entry does not have it yet.
+	assert.Nil(t, record.SetAny(entry, Position{X: 1, Y: 2}))
+	assert.Equal(t, Position{X: 1, Y: 2}, PositionComponent.GetValue(entry))
+
+	// Values of another type are rejected instead of being written into the component.
+	err := record.SetAny(entry, Velocity{X: 5, Y: 5})
+	assert.ErrorIs(t, err, esync.ErrComponentType)
+	assert.Equal(t, Position{X: 1, Y: 2}, PositionComponent.GetValue(entry))
+	assert.Equal(t, Velocity{}, VelocityComponent.GetValue(entry))
+}
+
+func TestComponentRecord_LerpAny(t *testing.T) {
+	record, ok := esync.LookupComponent[Position]()
+	assert.True(t, ok)
+	assert.True(t, record.Interpolated())
+
+	value, err := record.LerpAny(Position{X: 0, Y: 0}, Position{X: 10, Y: 20}, 0.5)
+	assert.Nil(t, err)
+	assert.Equal(t, Position{X: 5, Y: 10}, value)
+
+	velocity, ok := esync.LookupComponent[Velocity]()
+	assert.True(t, ok)
+	_, err = velocity.LerpAny(Velocity{}, Velocity{}, 0.5)
+	assert.ErrorIs(t, err, esync.ErrNoLerpFn)
+}
+
+type Scale struct {
+	X, Y float64
+}
+
+func TestRegisterComponent_FailingOption(t *testing.T) {
+	ctype := donburi.NewComponentType[Scale]()
+	lerpScale := func(from, to Scale, delta float64) Scale { return to }
+
+	// The interpolation ID is taken by Position, so nothing is registered.
+	err := esync.RegisterComponent(105, Scale{}, ctype, esync.WithLerpFn(100, lerpScale))
+	assert.ErrorIs(t, err, typemapper.ErrIdCollision)
+	assert.Nil(t, esync.Mapper.Lookup(105))
+	_, ok := esync.LookupComponent[Scale]()
+	assert.False(t, ok)
+
+	assert.Nil(t, esync.RegisterComponent(105, Scale{}, ctype, esync.WithLerpFn(105, lerpScale)))
+	record, ok := esync.LookupComponent[Scale]()
+	assert.True(t, ok)
+	assert.True(t, record.Interpolated())
+}
+
+type Heading struct {
+	Angle float64
+}
+
+type Spin struct {
+	Speed float64
+}
+
+func TestRegisterComponent_RollsBackOnFailure(t *testing.T) {
+	heading := donburi.NewComponentType[Heading]()
+	spin := donburi.NewComponentType[Spin]()
+	lerpHeading := func(from, to Heading, delta float64) Heading { return to }
+	lerpSpin := func(from, to Spin, delta float64) Spin { return to }
+
+	// The interpolation ID is validated, then taken by another component before it is registered.
+	takeKey := func(record *esync.ComponentRecord[Heading]) error {
+		return esync.RegisterComponent(108, Spin{}, spin, esync.WithLerpFn(107, lerpSpin))
+	}
+	err := esync.RegisterComponent(107, Heading{}, heading, esync.WithLerpFn(107, lerpHeading), takeKey)
+	assert.ErrorIs(t, err, typemapper.ErrIdCollision)
+	assert.Nil(t, esync.Mapper.Lookup(107))
+	_, ok := esync.LookupComponent[Heading]()
+	assert.False(t, ok)
+
+	// The component ID is free to be registered again.
+	assert.Nil(t, esync.RegisterComponent(107, Heading{}, heading))
+}
+
+type Size struct {
+	W, H float64
+}
+
+func TestWithInterpFn_Deprecated(t *testing.T) {
+	ctype := donburi.NewComponentType[Size]()
+	err := esync.RegisterComponent(106, Size{}, ctype, esync.WithInterpFn[Size](106, nil))
+	assert.ErrorIs(t, err, typemapper.ErrNilLerpFunction)
+
+	lerpSize := func(from, to Size, delta float64) *Size {
+		return &Size{W: from.W + (to.W-from.W)*delta, H: from.H + (to.H-from.H)*delta}
+	}
+	assert.Nil(t, esync.RegisterComponent(106, Size{}, ctype, esync.WithInterpFn(106, lerpSize)))
+
+	record, ok := esync.LookupInterpRecord(106)
+	assert.True(t, ok)
+	value, err := record.LerpAny(Size{}, Size{W: 2, H: 4}, 0.5)
+	assert.Nil(t, err)
+	assert.Equal(t, Size{W: 1, H: 2}, value)
+
+	// The setter is still a LerpFn for code that interpolates using reflection.
+	setter := esync.LookupInterpSetter(106).Interface().(esync.LerpFn[Size])
+	assert.Equal(t, &Size{W: 1, H: 2}, setter(Size{}, Size{W: 2, H: 4}, 0.5))
+}
+
+func TestComponentRecord_Codec(t *testing.T) {
+	record, _ := esync.LookupComponent[Position]()
+
+	data, err := record.Encode(Position{X: 3, Y: 4})
+	assert.Nil(t, err)
+
+	decoded, err := record.Decode(data)
+	assert.Nil(t, err)
+	assert.Equal(t, Position{X: 3, Y: 4}, decoded)
+}
+
+func TestWorldSnapshot_Binary(t *testing.T) {
+	snapshot := esync.WorldSnapshot{
+		{Id: esync.NewNetworkId(1, 0), State: esync.EntityState{2: []byte{1, 2, 3}}},
+		{Id: esync.NewNetworkId(300, 4), State: esync.EntityState{2: []byte{4}, 500: {}}},
+	}
+
+	data, err := snapshot.MarshalBinary()
+	assert.Nil(t, err)
+	// count, then index, generation, component count, component id, length and the data.
+	assert.Equal(t, []byte{2, 1, 0, 1, 2, 3, 1, 2, 3}, data[:9])
+
+	var decoded esync.WorldSnapshot
+	assert.Nil(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, snapshot, decoded)
+	assert.Equal(t, uint32(300), decoded[1].Id.Index())
+	assert.Equal(t, uint8(4), decoded[1].Id.Generation())
+
+	assert.ErrorIs(t, decoded.UnmarshalBinary(data[:len(data)-1]), esync.ErrMalformedSnapshot)
+}
+
+func TestHistory_RingBuffer(t *testing.T) {
+	record, ok := esync.LookupComponent[Position]()
+	assert.True(t, ok)
+
+	now := time.Now()
+	history := record.NewHistory(3)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, history.Push(Position{X: float64(i)}, now.Add(time.Duration(i)*time.Second)))
+	}
+
+	// Only the newest samples are kept, ordered from oldest to newest.
+	assert.Equal(t, 3, history.Len())
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, now.Add(time.Duration(i+2)*time.Second), history.Timestamp(i))
+	}
+
+	world := donburi.NewWorld()
+	entry := world.Entry(world.Create(PositionComponent))
+	history.Apply(entry, 0)
+	assert.Equal(t, Position{X: 2}, *PositionComponent.Get(entry))
+
+	var value any = Position{X: 5}
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = history.Push(value, now)
+	})
+	assert.Zero(t, allocs)
+}
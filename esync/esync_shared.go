@@ -3,8 +3,8 @@ package esync
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"reflect"
-	"unsafe"
 
 	"github.com/leap-fish/necs/typemapper"
 	"github.com/yohamta/donburi"
@@ -60,7 +60,6 @@ func (i *InterpData) ComponentKeys() []uint8 {
 }
 
 type ComponentId uint
-type NetworkId uint
 
 type EntityState map[ComponentId][]byte
 type SerializedEntity struct {
@@ -69,13 +68,29 @@ type SerializedEntity struct {
 }
 type WorldSnapshot []SerializedEntity
 
+// Despawn is sent by the server before a snapshot to tell clients which networked entities
+// have been removed or are no longer synchronized.
+type Despawn struct {
+	Ids []NetworkId
+}
+
+// ManifestName is the name the schema versions of the components are announced under in the
+// schema manifest of the router, by srvsync and clisync.
+const ManifestName = "esync"
+
 // LerpFn is used by the InterpolateSystem to properly lerp your component
+//
+// Deprecated: LerpFn allocates every interpolated value, use [InterpolateFn] with [WithLerpFn].
 type LerpFn[T any] func(from T, to T, delta float64) *T
 
+// InterpolateFn is used by the InterpolateSystem to interpolate between two values of your
+// component, see [WithLerpFn].
+type InterpolateFn[T any] func(from T, to T, delta float64) T
+
 var NetworkEntityQuery = donburi.NewQuery(filter.Contains(NetworkIdComponent))
 
 var interpolated = typemapper.NewComponentMapper()
-var registered = map[reflect.Type]donburi.IComponentType{}
+var records = map[reflect.Type]Record{}
 
 var (
 	Mapper             = typemapper.NewMapper(map[uint]any{})
@@ -96,10 +111,22 @@ func LookupInterpType(id uint8) reflect.Type {
 
 // LookupInterpSetter returns the setter function for the given interpolation ID.
 // This should always be type [esync.LerpFn]
+//
+// Deprecated: use [LookupInterpRecord], which interpolates without reflection.
 func LookupInterpSetter(id uint8) reflect.Value {
 	return interpolated.LookupSetter(id)
 }
 
+// LookupInterpRecord returns the component record for the given interpolation ID,
+// its lerp function is kept in the typed [ComponentRecord].
+func LookupInterpRecord(id uint8) (Record, bool) {
+	typ := interpolated.LookupType(id)
+	if typ == nil {
+		return nil, false
+	}
+	return LookupRecord(typ)
+}
+
 // RegisteredInterpId returns true if the given interpolation ID is registered.
 func RegisteredInterpId(id uint8) bool {
 	return interpolated.RegisteredId(id)
@@ -112,13 +139,17 @@ func RegisteredInterpType(typ reflect.Type) bool {
 }
 
 func Registered(componentType reflect.Type) (donburi.IComponentType, bool) {
-	ctype, ok := registered[componentType]
-	return ctype, ok
+	record, ok := records[componentType]
+	if !ok {
+		return nil, false
+	}
+	return record.Type(), true
 }
 
-type RegisterOption[T any] func(*donburi.ComponentType[T])
+// RegisterOption configures a component record while it is registered with [RegisterComponent].
+type RegisterOption[T any] func(*ComponentRecord[T]) error
 
-// WithInterpFn will utilize the given lerp function for client-side interpolation
+// WithLerpFn will utilize the given interpolation function for client-side interpolation
 // when registering with a component.
 //
 // For example you can provide the following for a basic Position Component:
@@ -133,54 +164,112 @@ type RegisterOption[T any] func(*donburi.ComponentType[T])
 //		return (1.0-t)*a + b*t
 //	}
 //
-//	func lerpVec2(from, to Vector2, delta float64) *Vector2 {
-//		return &Vector2{
+//	func lerpVec2(from, to Vector2, delta float64) Vector2 {
+//		return Vector2{
 //			X: lerp(from.X, to.X, delta),
-//			T: lerp(from.Y, to.Y, delta),
+//			Y: lerp(from.Y, to.Y, delta),
 //		}
 //	}
 //
-//	esync.RegisterComponent(10, Vector2{}, PositionComponent, esync.WithInterpFn(10, lerpVec2))
+//	esync.RegisterComponent(10, Vector2{}, PositionComponent, esync.WithLerpFn(10, lerpVec2))
+//
+// The function is kept typed in the component record, so interpolating does not
+// use reflection and does not allocate. For common types the built-in interpolators such as
+// [LerpVec2], [LerpAngle] and [SlerpQuat] can be used instead.
+func WithLerpFn[T any](id uint8, fn InterpolateFn[T]) RegisterOption[T] {
+	return func(record *ComponentRecord[T]) error {
+		if fn == nil {
+			return fmt.Errorf("%w: %w: %s", ErrNoLerpFn, typemapper.ErrNilLerpFunction, record.ctype.Typ())
+		}
+
+		// The key is registered by RegisterComponent, once all options succeeded.
+		err := interpolated.ValidateInterpolatedComponent(id, record.ctype)
+		if err != nil {
+			return err
+		}
+
+		record.interpKey = id
+		record.Lerp = fn
+		return nil
+	}
+}
+
+// WithInterpFn will utilize the given lerp function for client-side interpolation
+// when registering with a component.
+//
+// Deprecated: the lerp function allocates every interpolated value, use [WithLerpFn].
 func WithInterpFn[T any](id uint8, fn LerpFn[T]) RegisterOption[T] {
-	return func(ctype *donburi.ComponentType[T]) {
-		interpolated.RegisterInterpolatedComponent(id, ctype, fn)
+	if fn == nil {
+		return WithLerpFn[T](id, nil)
 	}
+
+	return WithLerpFn(id, func(from T, to T, delta float64) T {
+		return *fn(from, to, delta)
+	})
 }
 
 // RegisterComponent registers a component for use with esync. Make sure the client and server have the same definition of components.
 // Note that ID 1 is reserved for the NetworkId component used by esync.
 //
-// Optionally you may provide an optional [WithInterpFn] to register this component
+// Optionally you may provide an optional [WithLerpFn] to register this component
 // for interpolation.
 func RegisterComponent[T any](id uint, component any, ctype *donburi.ComponentType[T], opt ...RegisterOption[T]) error {
 	typ := reflect.TypeOf(component)
+
+	record := &ComponentRecord[T]{
+		id:    ComponentId(id),
+		ctype: ctype,
+	}
+
+	// Call the options before registering anything, so a failing option leaves no trace.
+	for _, o := range opt {
+		if err := o(record); err != nil {
+			return err
+		}
+	}
+
+	registered := Mapper.Lookup(id) == typ
 	err := Mapper.RegisterType(id, typ)
 	if err != nil {
 		return err
 	}
-	registered[typ] = ctype
 
-	// Call the options
-	for _, o := range opt {
-		o(ctype)
+	if record.interpKey != 0 {
+		err = interpolated.RegisterInterpolatedComponent(record.interpKey, ctype, record.setter())
+		if err != nil {
+			// The interpolation ID might have been taken since it was validated, the component
+			// ID is released again so the component is not left half registered.
+			if !registered {
+				Mapper.Unregister(id)
+			}
+			return err
+		}
 	}
 
+	records[typ] = record
+
 	return nil
 }
 
-// FindByNetworkId performs an "Each" query over network entities to find one with a matching ID.
-func FindByNetworkId(world donburi.World, networkId NetworkId) donburi.Entity {
-	var found donburi.Entity
-	NetworkEntityQuery.Each(world, func(entry *donburi.Entry) {
-		id := GetNetworkId(entry)
-		if id == nil || *id != networkId {
-			return
-		}
-
-		found = entry.Entity()
-	})
+// RegisterMigration registers an older schema version of the component type T, which must
+// already be registered using [RegisterComponent] and implement [typemapper.Versioned].
+//
+// Servers use down to send the older version to clients that have not been updated yet,
+// while up converts components of the older version to T.
+func RegisterMigration[T any, Old any](version uint, up func(Old) T, down func(T) Old) error {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+
+	return Mapper.RegisterLegacy(Mapper.LookupId(typ), version, reflect.TypeOf((*Old)(nil)).Elem(),
+		func(value any) any { return up(value.(Old)) },
+		func(value any) any { return down(value.(T)) },
+	)
+}
 
-	return found
+// FindByNetworkId returns the entity with a matching network ID using the network index of
+// the world, see [Index]. If there is none, [donburi.Null] is returned.
+func FindByNetworkId(world donburi.World, networkId NetworkId) donburi.Entity {
+	entity, _ := LookupEntity(world, networkId)
+	return entity
 }
 
 func GetNetworkId(entry *donburi.Entry) *NetworkId {
@@ -199,14 +288,3 @@ func GetNetworkId(entry *donburi.Entry) *NetworkId {
 	nid := NetworkIdComponent.Get(entry)
 	return nid
 }
-
-func ComponentFromVal(ctype donburi.IComponentType, value interface{}) unsafe.Pointer {
-	if reflect.TypeOf(value) != ctype.Typ() {
-		panic("Type assertion failed")
-	}
-	newVal := reflect.New(ctype.Typ()).Elem()
-	newVal.Set(reflect.ValueOf(value))
-	ptr := unsafe.Pointer(newVal.UnsafeAddr())
-
-	return ptr
-}
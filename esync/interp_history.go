@@ -0,0 +1,130 @@
+package esync
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yohamta/donburi"
+)
+
+// History is a buffer of the values received for an interpolated component of an entity,
+// ordered from oldest to newest. Samples are addressed by their position in the buffer,
+// which lets the interpolation system pick samples without knowing the component type.
+type History interface {
+	// Push appends a received value, dropping the oldest sample once the limit is reached.
+	Push(value any, ts time.Time) error
+	// Len returns the number of samples in the buffer.
+	Len() int
+	// Timestamp returns when the sample at position i was received.
+	Timestamp(i int) time.Time
+	// Apply sets the component of the entry to the value of the sample at position i.
+	Apply(entry *donburi.Entry, i int)
+	// Lerp interpolates between the samples at position from and to using the lerp or cubic
+	// function of the component, and sets the component of the entry to the result.
+	// The entry snaps to the sample at position to if the samples are further apart than
+	// the snap threshold of the component.
+	Lerp(entry *donburi.Entry, from, to int, delta float64)
+}
+
+type sample[T any] struct {
+	value T
+	ts    time.Time
+}
+
+// history is a fixed-capacity ring buffer, so pushing a sample never allocates.
+type history[T any] struct {
+	record  *ComponentRecord[T]
+	samples []sample[T]
+	start   int
+	count   int
+}
+
+// NewHistory creates a ring buffer holding up to limit samples of the component,
+// the limit is at least 1.
+func (r *ComponentRecord[T]) NewHistory(limit int) History {
+	return &history[T]{
+		record:  r,
+		samples: make([]sample[T], max(limit, 1)),
+	}
+}
+
+// at returns the sample at position i, counted from the oldest sample.
+func (h *history[T]) at(i int) *sample[T] {
+	return &h.samples[(h.start+i)%len(h.samples)]
+}
+
+func (h *history[T]) Push(value any, ts time.Time) error {
+	typed, ok := value.(T)
+	if !ok {
+		return fmt.Errorf("%w: expected %s, got %T", ErrComponentType, h.record.ctype.Typ(), value)
+	}
+
+	// Overwrite the oldest sample once the buffer is full.
+	if h.count == len(h.samples) {
+		h.samples[h.start] = sample[T]{value: typed, ts: ts}
+		h.start = (h.start + 1) % len(h.samples)
+		return nil
+	}
+
+	*h.at(h.count) = sample[T]{value: typed, ts: ts}
+	h.count++
+	return nil
+}
+
+func (h *history[T]) Len() int {
+	return h.count
+}
+
+func (h *history[T]) Timestamp(i int) time.Time {
+	return h.at(i).ts
+}
+
+func (h *history[T]) Apply(entry *donburi.Entry, i int) {
+	h.record.Set(entry, h.at(i).value)
+}
+
+func (h *history[T]) Lerp(entry *donburi.Entry, from, to int, delta float64) {
+	record := h.record
+	fromValue, toValue := h.at(from).value, h.at(to).value
+
+	if record.Distance != nil && record.Distance(fromValue, toValue) > record.SnapLimit {
+		h.Apply(entry, to)
+		return
+	}
+
+	if record.Cubic != nil {
+		// The surrounding samples are the ones beyond from and to, away from each other.
+		step := 1
+		if to < from {
+			step = -1
+		}
+		before, after := from, to
+		if from != to {
+			before = clampIndex(from-step, h.count)
+			after = clampIndex(to+step, h.count)
+		}
+
+		beforeValue, afterValue := h.at(before).value, h.at(after).value
+		// A surrounding sample past the snap threshold would make the spline overshoot.
+		if record.Distance != nil && record.Distance(beforeValue, fromValue) > record.SnapLimit {
+			beforeValue = fromValue
+		}
+		if record.Distance != nil && record.Distance(toValue, afterValue) > record.SnapLimit {
+			afterValue = toValue
+		}
+
+		record.Set(entry, record.Cubic(beforeValue, fromValue, toValue, afterValue, delta))
+		return
+	}
+
+	if record.Lerp == nil {
+		h.Apply(entry, to)
+		return
+	}
+
+	record.Set(entry, record.Lerp(fromValue, toValue, delta))
+}
+
+func clampIndex(i, length int) int {
+	return max(0, min(i, length-1))
+}
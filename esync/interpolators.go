@@ -0,0 +1,212 @@
+package esync
+
+import (
+	"fmt"
+	"math"
+)
+
+// Vec2 is a 2D vector component that can be interpolated with the built-in interpolators.
+type Vec2 struct {
+	X, Y float64
+}
+
+// Vec3 is a 3D vector component that can be interpolated with the built-in interpolators.
+type Vec3 struct {
+	X, Y, Z float64
+}
+
+// Angle is a rotation in radians. It is interpolated along the shortest arc, so
+// interpolating from just below 2π to just above 0 does not spin the other way around.
+type Angle float64
+
+// Quat is a rotation quaternion, it is interpolated using spherical linear interpolation.
+type Quat struct {
+	X, Y, Z, W float64
+}
+
+// CubicFn is used for cubic interpolation between from and to, before and after are the
+// samples surrounding them in the history buffer and are used to calculate the tangents.
+// When there are no surrounding samples, before is the same as from and after the same as to.
+type CubicFn[T any] func(before, from, to, after T, delta float64) T
+
+// DistanceFn returns the distance between two values of a component, see [WithSnapThreshold].
+type DistanceFn[T any] func(from, to T) float64
+
+// WithCubicFn will use the given cubic function instead of the lerp function for client-side
+// interpolation, it should be combined with [WithLerpFn]:
+//
+//	esync.RegisterComponent(10, esync.Vec2{}, PositionComponent,
+//		esync.WithLerpFn(10, esync.LerpVec2),
+//		esync.WithCubicFn(esync.HermiteVec2),
+//	)
+func WithCubicFn[T any](fn CubicFn[T]) RegisterOption[T] {
+	return func(record *ComponentRecord[T]) error {
+		if fn == nil {
+			return fmt.Errorf("%w: %s", ErrNoLerpFn, record.ctype.Typ())
+		}
+
+		record.Cubic = fn
+		return nil
+	}
+}
+
+// WithSnapThreshold will snap the component to the newer sample instead of interpolating
+// when the distance between the samples is larger than limit, for example when an entity
+// has been teleported.
+func WithSnapThreshold[T any](distance DistanceFn[T], limit float64) RegisterOption[T] {
+	return func(record *ComponentRecord[T]) error {
+		if distance == nil {
+			return fmt.Errorf("%w: snap threshold of %s", ErrNoDistanceFn, record.ctype.Typ())
+		}
+
+		record.Distance = distance
+		record.SnapLimit = limit
+		return nil
+	}
+}
+
+// Lerp linearly interpolates between a and b.
+func Lerp(a, b, t float64) float64 {
+	return (1.0-t)*a + b*t
+}
+
+// Hermite interpolates between p1 and p2 using a cubic Hermite spline, the tangents are
+// calculated from the surrounding points p0 and p3 (Catmull-Rom).
+func Hermite(p0, p1, p2, p3, t float64) float64 {
+	m1 := (p2 - p0) / 2
+	m2 := (p3 - p1) / 2
+
+	t2 := t * t
+	t3 := t2 * t
+
+	return (2*t3-3*t2+1)*p1 + (t3-2*t2+t)*m1 + (-2*t3+3*t2)*p2 + (t3-t2)*m2
+}
+
+func LerpVec2(from, to Vec2, delta float64) Vec2 {
+	return Vec2{
+		X: Lerp(from.X, to.X, delta),
+		Y: Lerp(from.Y, to.Y, delta),
+	}
+}
+
+func HermiteVec2(before, from, to, after Vec2, delta float64) Vec2 {
+	return Vec2{
+		X: Hermite(before.X, from.X, to.X, after.X, delta),
+		Y: Hermite(before.Y, from.Y, to.Y, after.Y, delta),
+	}
+}
+
+func DistanceVec2(from, to Vec2) float64 {
+	return math.Hypot(to.X-from.X, to.Y-from.Y)
+}
+
+func LerpVec3(from, to Vec3, delta float64) Vec3 {
+	return Vec3{
+		X: Lerp(from.X, to.X, delta),
+		Y: Lerp(from.Y, to.Y, delta),
+		Z: Lerp(from.Z, to.Z, delta),
+	}
+}
+
+func HermiteVec3(before, from, to, after Vec3, delta float64) Vec3 {
+	return Vec3{
+		X: Hermite(before.X, from.X, to.X, after.X, delta),
+		Y: Hermite(before.Y, from.Y, to.Y, after.Y, delta),
+		Z: Hermite(before.Z, from.Z, to.Z, after.Z, delta),
+	}
+}
+
+func DistanceVec3(from, to Vec3) float64 {
+	x, y, z := to.X-from.X, to.Y-from.Y, to.Z-from.Z
+	return math.Sqrt(x*x + y*y + z*z)
+}
+
+// angleDiff returns the signed shortest difference from a to b, in the range [-π, π).
+func angleDiff(a, b float64) float64 {
+	diff := math.Mod(b-a+math.Pi, 2*math.Pi)
+	if diff < 0 {
+		diff += 2 * math.Pi
+	}
+	return diff - math.Pi
+}
+
+// wrapAngle wraps the angle to the range [0, 2π).
+func wrapAngle(a float64) Angle {
+	a = math.Mod(a, 2*math.Pi)
+	if a < 0 {
+		a += 2 * math.Pi
+	}
+	return Angle(a)
+}
+
+// LerpAngle interpolates along the shortest arc between the angles, the result is wrapped
+// to the range [0, 2π).
+func LerpAngle(from, to Angle, delta float64) Angle {
+	return wrapAngle(float64(from) + angleDiff(float64(from), float64(to))*delta)
+}
+
+// HermiteAngle interpolates along a cubic Hermite spline between the angles, unwrapping the
+// surrounding angles so the spline never crosses the wrap-around.
+func HermiteAngle(before, from, to, after Angle, delta float64) Angle {
+	p1 := float64(from)
+	p0 := p1 - angleDiff(float64(before), p1)
+	p2 := p1 + angleDiff(p1, float64(to))
+	p3 := p2 + angleDiff(float64(to), float64(after))
+
+	return wrapAngle(Hermite(p0, p1, p2, p3, delta))
+}
+
+// DistanceAngle returns the length of the shortest arc between the angles.
+func DistanceAngle(from, to Angle) float64 {
+	return math.Abs(angleDiff(float64(from), float64(to)))
+}
+
+func (q Quat) dot(o Quat) float64 {
+	return q.X*o.X + q.Y*o.Y + q.Z*o.Z + q.W*o.W
+}
+
+func (q Quat) scale(s float64) Quat {
+	return Quat{X: q.X * s, Y: q.Y * s, Z: q.Z * s, W: q.W * s}
+}
+
+func (q Quat) add(o Quat) Quat {
+	return Quat{X: q.X + o.X, Y: q.Y + o.Y, Z: q.Z + o.Z, W: q.W + o.W}
+}
+
+// Normalize returns the quaternion scaled to unit length.
+func (q Quat) Normalize() Quat {
+	length := math.Sqrt(q.dot(q))
+	if length == 0 {
+		return Quat{W: 1}
+	}
+	return q.scale(1 / length)
+}
+
+// SlerpQuat spherically interpolates between the rotations along the shortest path.
+func SlerpQuat(from, to Quat, delta float64) Quat {
+	from, to = from.Normalize(), to.Normalize()
+
+	cos := from.dot(to)
+	// q and -q are the same rotation, flip one of them to take the shortest path.
+	if cos < 0 {
+		to = to.scale(-1)
+		cos = -cos
+	}
+
+	// Fall back to a normalized lerp when the rotations are almost the same, as sin(θ)
+	// gets too close to zero.
+	if cos > 0.9995 {
+		return from.scale(1 - delta).add(to.scale(delta)).Normalize()
+	}
+
+	theta := math.Acos(cos)
+	sin := math.Sin(theta)
+
+	return from.scale(math.Sin((1-delta)*theta) / sin).add(to.scale(math.Sin(delta*theta) / sin))
+}
+
+// DistanceQuat returns the angle in radians of the rotation between the quaternions.
+func DistanceQuat(from, to Quat) float64 {
+	cos := math.Abs(from.Normalize().dot(to.Normalize()))
+	return 2 * math.Acos(math.Min(cos, 1))
+}
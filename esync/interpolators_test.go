@@ -0,0 +1,104 @@
+package esync_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/leap-fish/necs/esync"
+	"github.com/stretchr/testify/assert"
+	"github.com/yohamta/donburi"
+)
+
+type Rotation struct {
+	esync.Quat
+}
+
+var (
+	HeadingComponent  = donburi.NewComponentType[esync.Angle]()
+	LocationComponent = donburi.NewComponentType[esync.Vec2]()
+)
+
+func init() {
+	_ = esync.RegisterComponent(102, esync.Angle(0), HeadingComponent,
+		esync.WithLerpFn(102, esync.LerpAngle),
+	)
+	_ = esync.RegisterComponent(103, esync.Vec2{}, LocationComponent,
+		esync.WithLerpFn(103, esync.LerpVec2),
+		esync.WithCubicFn(esync.HermiteVec2),
+		esync.WithSnapThreshold(esync.DistanceVec2, 100),
+	)
+}
+
+func TestLerpAngle_WrapsAround(t *testing.T) {
+	from := esync.Angle(2*math.Pi - 0.1)
+	to := esync.Angle(0.1)
+
+	assert.InDelta(t, 0, float64(esync.LerpAngle(from, to, 0.5)), 1e-9)
+	assert.InDelta(t, 2*math.Pi-0.05, float64(esync.LerpAngle(from, to, 0.25)), 1e-9)
+	assert.InDelta(t, 0.2, esync.DistanceAngle(from, to), 1e-9)
+}
+
+func TestHermite_PassesThroughSamples(t *testing.T) {
+	before, from, to, after := esync.Vec2{X: 0}, esync.Vec2{X: 1}, esync.Vec2{X: 2}, esync.Vec2{X: 3}
+
+	assert.Equal(t, from, esync.HermiteVec2(before, from, to, after, 0))
+	assert.Equal(t, to, esync.HermiteVec2(before, from, to, after, 1))
+	// Evenly spaced samples on a line stay on the line.
+	assert.InDelta(t, 1.5, esync.HermiteVec2(before, from, to, after, 0.5).X, 1e-9)
+}
+
+func TestSlerpQuat(t *testing.T) {
+	identity := esync.Quat{W: 1}
+	// 90 degrees around the Z axis.
+	quarter := esync.Quat{Z: math.Sin(math.Pi / 4), W: math.Cos(math.Pi / 4)}
+
+	half := esync.SlerpQuat(identity, quarter, 0.5)
+	assert.InDelta(t, math.Sin(math.Pi/8), half.Z, 1e-9)
+	assert.InDelta(t, math.Cos(math.Pi/8), half.W, 1e-9)
+	assert.InDelta(t, math.Pi/4, esync.DistanceQuat(identity, half), 1e-9)
+
+	// The negated quaternion is the same rotation, so the shortest path is taken.
+	negated := esync.SlerpQuat(identity, esync.Quat{Z: -quarter.Z, W: -quarter.W}, 0.5)
+	assert.InDelta(t, half.Z, negated.Z, 1e-9)
+	assert.InDelta(t, half.W, negated.W, 1e-9)
+}
+
+func TestHistory_SnapThreshold(t *testing.T) {
+	world := donburi.NewWorld()
+	entry := world.Entry(world.Create(LocationComponent))
+
+	record, ok := esync.LookupComponent[esync.Vec2]()
+	assert.True(t, ok)
+
+	now := time.Now()
+	history := record.NewHistory(4)
+	assert.Nil(t, history.Push(esync.Vec2{X: 0}, now))
+	assert.Nil(t, history.Push(esync.Vec2{X: 10}, now))
+	assert.Nil(t, history.Push(esync.Vec2{X: 500}, now))
+
+	// The teleported sample is not used as a tangent either.
+	history.Lerp(entry, 0, 1, 0.5)
+	assert.Less(t, LocationComponent.Get(entry).X, 10.0)
+	assert.Greater(t, LocationComponent.Get(entry).X, 0.0)
+
+	// The entity has been teleported, so it snaps instead of interpolating.
+	history.Lerp(entry, 1, 2, 0.5)
+	assert.Equal(t, esync.Vec2{X: 500}, *LocationComponent.Get(entry))
+}
+
+func TestWithCubicFn_Nil(t *testing.T) {
+	ctype := donburi.NewComponentType[Rotation]()
+	err := esync.RegisterComponent(104, Rotation{}, ctype, esync.WithCubicFn[Rotation](nil))
+	assert.ErrorIs(t, err, esync.ErrNoLerpFn)
+
+	// The ID is not claimed by the failed registration.
+	assert.Nil(t, esync.Mapper.Lookup(104))
+}
+
+func TestWithSnapThreshold_Nil(t *testing.T) {
+	ctype := donburi.NewComponentType[Rotation]()
+	err := esync.RegisterComponent(104, Rotation{}, ctype, esync.WithSnapThreshold[Rotation](nil, 1))
+	assert.ErrorIs(t, err, esync.ErrNoDistanceFn)
+	assert.NotErrorIs(t, err, esync.ErrNoLerpFn)
+}
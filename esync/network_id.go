@@ -0,0 +1,37 @@
+package esync
+
+import "fmt"
+
+const (
+	// NetworkIdIndexBits is the number of bits of a NetworkId used for its index,
+	// the remaining bits hold the generation.
+	NetworkIdIndexBits = 24
+	// MaxNetworkIdIndex is the highest index a NetworkId can have.
+	MaxNetworkIdIndex = 1<<NetworkIdIndexBits - 1
+)
+
+// NetworkId identifies a networked entity between the server and its clients.
+//
+// The lower 24 bits hold the index, which the server recycles once an entity is removed,
+// and the upper 8 bits hold the generation, which is incremented every time the index is
+// reused. This way a recycled ID never equals the ID of the entity that used the index before.
+type NetworkId uint32
+
+// NewNetworkId creates a NetworkId from an index and a generation.
+func NewNetworkId(index uint32, generation uint8) NetworkId {
+	return NetworkId(uint32(generation)<<NetworkIdIndexBits | index&MaxNetworkIdIndex)
+}
+
+// Index returns the recyclable index of the ID.
+func (id NetworkId) Index() uint32 {
+	return uint32(id) & MaxNetworkIdIndex
+}
+
+// Generation returns how many times the index of the ID has been reused.
+func (id NetworkId) Generation() uint8 {
+	return uint8(uint32(id) >> NetworkIdIndexBits)
+}
+
+func (id NetworkId) String() string {
+	return fmt.Sprintf("%d:%d", id.Index(), id.Generation())
+}
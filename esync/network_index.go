@@ -0,0 +1,181 @@
+package esync
+
+import (
+	"sync"
+
+	"github.com/yohamta/donburi"
+)
+
+// NetworkIndex maps network IDs to the entities of a world.
+// It is kept up to date by esync when entities are synced, spawned or removed, including
+// entities that are removed from the world directly instead of through esync.
+type NetworkIndex struct {
+	mutex    sync.RWMutex
+	world    donburi.World
+	entities map[NetworkId]donburi.Entity
+	// slots maps the index of each network ID to the generation currently using it.
+	slots map[uint32]NetworkId
+}
+
+var (
+	indexes = map[donburi.WorldId]*NetworkIndex{}
+	// hooked contains the worlds whose remove hook is registered. Hooks cannot be removed from a
+	// world, so it is registered once and outlives the indexes dropped with DropIndex.
+	hooked       = map[donburi.WorldId]bool{}
+	indexesMutex sync.Mutex
+)
+
+// Index returns the network index of the world, creating it on first use. The index is kept
+// until the world is dropped with [DropIndex].
+func Index(world donburi.World) *NetworkIndex {
+	indexesMutex.Lock()
+	defer indexesMutex.Unlock()
+
+	index, ok := indexes[world.Id()]
+	if ok {
+		return index
+	}
+
+	index = &NetworkIndex{
+		world:    world,
+		entities: make(map[NetworkId]donburi.Entity),
+		slots:    make(map[uint32]NetworkId),
+	}
+
+	// Pick up entities that were given a network ID before the index existed.
+	NetworkEntityQuery.Each(world, func(entry *donburi.Entry) {
+		index.Set(NetworkIdComponent.GetValue(entry), entry.Entity())
+	})
+
+	if !hooked[world.Id()] {
+		world.OnRemove(removeFromIndex)
+		hooked[world.Id()] = true
+	}
+
+	indexes[world.Id()] = index
+	return index
+}
+
+// removeFromIndex removes the entity from the current index of the world. It is called before
+// the entity is removed, so the network ID can still be read.
+func removeFromIndex(world donburi.World, entity donburi.Entity) {
+	entry := world.Entry(entity)
+	if !entry.HasComponent(NetworkIdComponent) {
+		return
+	}
+
+	indexesMutex.Lock()
+	index, ok := indexes[world.Id()]
+	indexesMutex.Unlock()
+	if !ok {
+		return
+	}
+
+	index.remove(NetworkIdComponent.GetValue(entry), entity)
+}
+
+// DropIndex forgets the network index of the world. Indexes are kept until they are dropped,
+// so it should be called when a world is discarded, such as when a match ends. Using the world
+// with esync again creates a new index.
+func DropIndex(world donburi.World) {
+	indexesMutex.Lock()
+	defer indexesMutex.Unlock()
+
+	delete(indexes, world.Id())
+}
+
+// Lookup returns the entity with the given network ID.
+func (i *NetworkIndex) Lookup(id NetworkId) (donburi.Entity, bool) {
+	i.mutex.RLock()
+	entity, ok := i.entities[id]
+	i.mutex.RUnlock()
+
+	if !ok {
+		return donburi.Null, false
+	}
+
+	// The network ID component might have been removed or changed outside of esync.
+	if !i.world.Valid(entity) {
+		i.remove(id, entity)
+		return donburi.Null, false
+	}
+	nid := GetNetworkId(i.world.Entry(entity))
+	if nid == nil || *nid != id {
+		i.remove(id, entity)
+		return donburi.Null, false
+	}
+
+	return entity, true
+}
+
+// Set maps the network ID to the entity.
+func (i *NetworkIndex) Set(id NetworkId, entity donburi.Entity) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.entities[id] = entity
+	i.slots[id.Index()] = id
+}
+
+// Remove removes the network ID from the index.
+func (i *NetworkIndex) Remove(id NetworkId) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	delete(i.entities, id)
+	if i.slots[id.Index()] == id {
+		delete(i.slots, id.Index())
+	}
+}
+
+// Stale returns the ID and entity of an older or newer generation of the given network ID,
+// if one is still indexed. Clients use this to detect that the server has reused an ID.
+func (i *NetworkIndex) Stale(id NetworkId) (NetworkId, donburi.Entity, bool) {
+	i.mutex.RLock()
+	current, ok := i.slots[id.Index()]
+	i.mutex.RUnlock()
+
+	if !ok || current == id {
+		return 0, donburi.Null, false
+	}
+
+	entity, ok := i.Lookup(current)
+	return current, entity, ok
+}
+
+// Len returns the number of indexed network IDs.
+func (i *NetworkIndex) Len() int {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	return len(i.entities)
+}
+
+// remove only removes the network ID if it still maps to the given entity,
+// so a newer mapping for the same ID is left intact.
+func (i *NetworkIndex) remove(id NetworkId, entity donburi.Entity) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.entities[id] == entity {
+		delete(i.entities, id)
+		if i.slots[id.Index()] == id {
+			delete(i.slots, id.Index())
+		}
+	}
+}
+
+// SetNetworkId gives the entry a network ID and adds it to the network index of its world.
+func SetNetworkId(entry *donburi.Entry, id NetworkId) {
+	if !entry.HasComponent(NetworkIdComponent) {
+		entry.AddComponent(NetworkIdComponent)
+	}
+	NetworkIdComponent.SetValue(entry, id)
+
+	Index(entry.World).Set(id, entry.Entity())
+}
+
+// LookupEntity returns the entity with the given network ID in the world.
+func LookupEntity(world donburi.World, id NetworkId) (donburi.Entity, bool) {
+	return Index(world).Lookup(id)
+}
@@ -0,0 +1,59 @@
+package esync_test
+
+import (
+	"testing"
+
+	"github.com/leap-fish/necs/esync"
+	"github.com/stretchr/testify/assert"
+	"github.com/yohamta/donburi"
+)
+
+func TestNetworkIndex(t *testing.T) {
+	world := donburi.NewWorld()
+
+	// Entities that already have a network ID are indexed when the index is created.
+	existing := world.Entry(world.Create(esync.NetworkIdComponent))
+	esync.NetworkIdComponent.SetValue(existing, 7)
+
+	index := esync.Index(world)
+	entity, ok := index.Lookup(7)
+	assert.True(t, ok)
+	assert.Equal(t, existing.Entity(), entity)
+
+	spawned := world.Entry(world.Create(PositionComponent))
+	esync.SetNetworkId(spawned, 8)
+	assert.Equal(t, spawned.Entity(), esync.FindByNetworkId(world, 8))
+	assert.Equal(t, 2, index.Len())
+
+	// Removing the entity outside of esync removes it from the index.
+	world.Remove(spawned.Entity())
+	_, ok = esync.LookupEntity(world, 8)
+	assert.False(t, ok)
+	assert.Equal(t, 1, index.Len())
+
+	// Stale mappings are dropped when the network ID changes.
+	esync.NetworkIdComponent.SetValue(existing, 9)
+	_, ok = index.Lookup(7)
+	assert.False(t, ok)
+	assert.Equal(t, donburi.Null, esync.FindByNetworkId(world, 7))
+}
+
+func TestDropIndex(t *testing.T) {
+	world := donburi.NewWorld()
+	entry := world.Entry(world.Create(PositionComponent))
+	esync.SetNetworkId(entry, 3)
+	index := esync.Index(world)
+
+	esync.DropIndex(world)
+
+	// The world gets a new index, which picks up the networked entities again.
+	assert.NotSame(t, index, esync.Index(world))
+	entity, ok := esync.LookupEntity(world, 3)
+	assert.True(t, ok)
+	assert.Equal(t, entry.Entity(), entity)
+
+	// Only the current index is kept up to date, the dropped one is left alone.
+	world.Remove(entry.Entity())
+	assert.Equal(t, 0, esync.Index(world).Len())
+	assert.Equal(t, 1, index.Len())
+}
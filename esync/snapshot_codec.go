@@ -0,0 +1,134 @@
+package esync
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var ErrMalformedSnapshot = errors.New("malformed snapshot")
+
+// MarshalBinary encodes the snapshot in a compact format, using varints for the entity
+// count, network IDs, component IDs and lengths.
+//
+// Each entity is encoded as the index of its network ID, its generation as a single byte,
+// and its components as pairs of component ID and serialized component.
+func (s WorldSnapshot) MarshalBinary() ([]byte, error) {
+	size := binary.MaxVarintLen64
+	for _, entity := range s {
+		size += binary.MaxVarintLen32 + 1 + binary.MaxVarintLen64
+		for _, data := range entity.State {
+			size += 2*binary.MaxVarintLen64 + len(data)
+		}
+	}
+
+	buf := make([]byte, 0, size)
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+
+	for _, entity := range s {
+		buf = binary.AppendUvarint(buf, uint64(entity.Id.Index()))
+		buf = append(buf, entity.Id.Generation())
+		buf = binary.AppendUvarint(buf, uint64(len(entity.State)))
+
+		for componentId, data := range entity.State {
+			buf = binary.AppendUvarint(buf, uint64(componentId))
+			buf = binary.AppendUvarint(buf, uint64(len(data)))
+			buf = append(buf, data...)
+		}
+	}
+
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a snapshot encoded with [WorldSnapshot.MarshalBinary].
+func (s *WorldSnapshot) UnmarshalBinary(data []byte) error {
+	r := snapshotReader{data: data}
+
+	count := r.uvarint()
+	if r.err != nil {
+		return r.err
+	}
+	if count > uint64(len(data)) {
+		return fmt.Errorf("%w: entity count %d exceeds snapshot size", ErrMalformedSnapshot, count)
+	}
+
+	snapshot := make(WorldSnapshot, 0, count)
+	for i := uint64(0); i < count; i++ {
+		index := r.uvarint()
+		generation := r.byte()
+		components := r.uvarint()
+		if r.err != nil {
+			return r.err
+		}
+		if index > MaxNetworkIdIndex || components > uint64(len(data)) {
+			return fmt.Errorf("%w: invalid entity header", ErrMalformedSnapshot)
+		}
+
+		state := make(EntityState, components)
+		for j := uint64(0); j < components; j++ {
+			componentId := r.uvarint()
+			state[ComponentId(componentId)] = r.bytes(r.uvarint())
+		}
+		if r.err != nil {
+			return r.err
+		}
+
+		snapshot = append(snapshot, SerializedEntity{
+			Id:    NewNetworkId(uint32(index), generation),
+			State: state,
+		})
+	}
+
+	*s = snapshot
+	return nil
+}
+
+type snapshotReader struct {
+	data []byte
+	err  error
+}
+
+func (r *snapshotReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+
+	value, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = fmt.Errorf("%w: invalid varint", ErrMalformedSnapshot)
+		return 0
+	}
+
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *snapshotReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+
+	if len(r.data) < 1 {
+		r.err = fmt.Errorf("%w: unexpected end of data", ErrMalformedSnapshot)
+		return 0
+	}
+
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *snapshotReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if uint64(len(r.data)) < n {
+		r.err = fmt.Errorf("%w: unexpected end of data", ErrMalformedSnapshot)
+		return nil
+	}
+
+	b := r.data[:n:n]
+	r.data = r.data[n:]
+	return b
+}
@@ -0,0 +1,147 @@
+package srvsync
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/leap-fish/necs/esync"
+	"github.com/yohamta/donburi"
+)
+
+var (
+	despawned    []esync.NetworkId
+	despawnedMtx sync.Mutex
+)
+
+// NetworkUnsync stops synchronizing the entity, the entity itself stays in the world but loses
+// its network ID. Clients are told to despawn it with the next [DoSync].
+func NetworkUnsync(entity donburi.Entity) error {
+	if !world.Valid(entity) {
+		return fmt.Errorf("entity %d is not valid", entity.Id())
+	}
+
+	entry := world.Entry(entity)
+	if !unsync(entry) {
+		return fmt.Errorf("entity %d is not synced", entity.Id())
+	}
+
+	entry.RemoveComponent(esync.NetworkIdComponent)
+	if entry.HasComponent(esync.InterpComponent) {
+		entry.RemoveComponent(esync.InterpComponent)
+	}
+
+	return nil
+}
+
+// NetworkDespawn removes the entity from the world and tells clients to despawn it with the
+// next [DoSync]. Removing a synced entity from the world directly has the same effect.
+func NetworkDespawn(entity donburi.Entity) error {
+	if !world.Valid(entity) {
+		return fmt.Errorf("entity %d is not valid", entity.Id())
+	}
+
+	world.Remove(entity)
+	return nil
+}
+
+// SetSyncedComponents replaces the list of components synchronized for an entity that is
+// already synced. This accepts the same components and options as [NetworkSync].
+func SetSyncedComponents(entity donburi.Entity, components ...any) error {
+	if !synced(entity) {
+		return fmt.Errorf("entity %d is not synced", entity.Id())
+	}
+
+	return NetworkSync(world, &entity, components...)
+}
+
+// AddSyncedComponents adds components to the list of components synchronized for an entity.
+func AddSyncedComponents(entity donburi.Entity, components ...donburi.IComponentType) error {
+	entry := world.Entry(entity)
+	for _, comp := range components {
+		if !entry.HasComponent(comp) {
+			return fmt.Errorf("entity %d does not have the component %s", entry.Id(), comp.Name())
+		}
+	}
+
+	syncEntMtx.Lock()
+	defer syncEntMtx.Unlock()
+
+	list, ok := syncEntities[entity]
+	if !ok {
+		return fmt.Errorf("entity %d is not synced", entity.Id())
+	}
+
+	for _, comp := range components {
+		if !slices.Contains(list, comp) {
+			list = append(list, comp)
+		}
+	}
+	syncEntities[entity] = list
+
+	return nil
+}
+
+// RemoveSyncedComponents removes components from the list of components synchronized for an entity.
+// The components stay on the entity on the server, but are no longer sent to clients.
+func RemoveSyncedComponents(entity donburi.Entity, components ...donburi.IComponentType) error {
+	syncEntMtx.Lock()
+	defer syncEntMtx.Unlock()
+
+	list, ok := syncEntities[entity]
+	if !ok {
+		return fmt.Errorf("entity %d is not synced", entity.Id())
+	}
+
+	syncEntities[entity] = slices.DeleteFunc(slices.Clone(list), func(comp donburi.IComponentType) bool {
+		return slices.Contains(components, comp)
+	})
+
+	return nil
+}
+
+func synced(entity donburi.Entity) bool {
+	syncEntMtx.RLock()
+	defer syncEntMtx.RUnlock()
+
+	_, ok := syncEntities[entity]
+	return ok
+}
+
+// unsync forgets the entity, releases its network ID and queues the despawn for clients.
+// It returns false if the entity was not synced.
+func unsync(entry *donburi.Entry) bool {
+	syncEntMtx.Lock()
+	_, ok := syncEntities[entry.Entity()]
+	delete(syncEntities, entry.Entity())
+	syncEntMtx.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	id := esync.GetNetworkId(entry)
+	if id == nil {
+		return true
+	}
+
+	esync.Index(entry.World).Remove(*id)
+	NetworkIds.Release(*id)
+
+	despawnedMtx.Lock()
+	defer despawnedMtx.Unlock()
+	despawned = append(despawned, *id)
+
+	return true
+}
+
+// takeDespawned returns the despawns that have not been sent to clients yet.
+func takeDespawned() esync.Despawn {
+	despawnedMtx.Lock()
+	defer despawnedMtx.Unlock()
+
+	ids := despawned
+	despawned = nil
+
+	return esync.Despawn{Ids: ids}
+}
@@ -0,0 +1,69 @@
+package srvsync
+
+import (
+	"testing"
+
+	"github.com/leap-fish/necs/esync"
+	"github.com/stretchr/testify/assert"
+	"github.com/yohamta/donburi"
+)
+
+type Health struct {
+	Current, Max uint8
+}
+
+var HealthComponent = donburi.NewComponentType[Health]()
+
+func TestNetworkUnsyncAndDespawn(t *testing.T) {
+	w := donburi.NewWorld()
+	UseEsync(w)
+	_ = takeDespawned()
+
+	kept := w.Create(HealthComponent)
+	removed := w.Create(HealthComponent)
+	assert.Nil(t, NetworkSync(w, &kept, HealthComponent))
+	assert.Nil(t, NetworkSync(w, &removed, HealthComponent))
+
+	keptId := *esync.GetNetworkId(w.Entry(kept))
+	removedId := *esync.GetNetworkId(w.Entry(removed))
+
+	// Syncing again keeps the network ID.
+	assert.Nil(t, SetSyncedComponents(kept, HealthComponent))
+	assert.Equal(t, keptId, *esync.GetNetworkId(w.Entry(kept)))
+
+	assert.Nil(t, NetworkUnsync(kept))
+	assert.True(t, w.Valid(kept))
+	assert.False(t, w.Entry(kept).HasComponent(esync.NetworkIdComponent))
+	assert.NotNil(t, NetworkUnsync(kept))
+
+	// Removing the entity directly cleans up the same way as NetworkDespawn.
+	w.Remove(removed)
+
+	assert.Empty(t, syncEntities)
+	assert.False(t, NetworkIds.Alive(keptId))
+	assert.False(t, NetworkIds.Alive(removedId))
+	assert.Equal(t, []esync.NetworkId{keptId, removedId}, takeDespawned().Ids)
+	assert.Empty(t, takeDespawned().Ids)
+}
+
+func TestSyncedComponents(t *testing.T) {
+	w := donburi.NewWorld()
+	UseEsync(w)
+
+	entity := w.Create(HealthComponent, esync.InterpComponent)
+	assert.Nil(t, NetworkSync(w, &entity))
+	assert.Nil(t, AddSyncedComponents(entity, HealthComponent))
+	assert.Equal(t, []donburi.IComponentType{esync.NetworkIdComponent, HealthComponent}, syncEntities[entity])
+
+	assert.Nil(t, RemoveSyncedComponents(entity, HealthComponent))
+	assert.Equal(t, []donburi.IComponentType{esync.NetworkIdComponent}, syncEntities[entity])
+
+	// The network ID is still sent.
+	state, err := buildEntityState(w.Entry(entity), nil)
+	assert.Nil(t, err)
+	assert.Contains(t, state, esync.ComponentId(1))
+
+	assert.Nil(t, NetworkDespawn(entity))
+	assert.False(t, w.Valid(entity))
+	assert.NotContains(t, syncEntities, entity)
+}
@@ -2,7 +2,7 @@ package srvsync
 
 import (
 	"bytes"
-	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"slices"
@@ -13,10 +13,11 @@ import (
 	"github.com/leap-fish/necs/router"
 	"github.com/yohamta/donburi"
 	"github.com/yohamta/donburi/component"
-	"golang.org/x/sync/errgroup"
 )
 
-var NetworkIdCounter = atomic.Uint64{}
+// NetworkIds allocates the network IDs of synced entities, the IDs of removed entities are
+// recycled with a new generation.
+var NetworkIds = NewIdAllocator()
 
 var syncEntities = map[donburi.Entity][]component.IComponentType{}
 
@@ -29,13 +30,62 @@ var world donburi.World
 
 func init() {
 	_ = esync.RegisterComponent(1, esync.NetworkId(0), esync.NetworkIdComponent)
+
+	// Peers are told which component versions this side understands.
+	router.AddManifest(esync.ManifestName, esync.Mapper.Manifest)
 }
 
-// UseEsync is used to set the world instance to use for synchronization.
+// UseEsync is used to set the world instance to use for synchronization. Calling it again, such
+// as after the router was reset or with a new world, replaces the previous registration.
 func UseEsync(w donburi.World) {
 	world = w
+	current := registration.Add(1)
+
+	// Only the newest snapshot matters, stale ones are dropped instead of blocking the next one.
+	router.SetChannel[esync.WorldSnapshot](router.UnreliableSequenced)
+
+	// Clients that resume their session missed everything sent while they were away, the
+	// snapshot brings them up to date and despawns what was removed in the meantime.
+	router.OnResume(func(sender *router.NetworkClient) {
+		if registration.Load() != current {
+			return
+		}
+		if _, ok := sender.Manifest(esync.ManifestName); !ok {
+			return
+		}
+
+		syncMutex.Lock()
+		defer syncMutex.Unlock()
+
+		_ = sender.SendMessage(buildSnapshot(sender, world))
+	})
+
+	// Hooks cannot be removed from a world, so they are only registered once per world.
+	hookedWorldsMutex.Lock()
+	defer hookedWorldsMutex.Unlock()
+	if hookedWorlds[w.Id()] {
+		return
+	}
+	hookedWorlds[w.Id()] = true
+
+	// Called before the entity is removed, so its network ID can still be read.
+	w.OnRemove(func(removedFrom donburi.World, entity donburi.Entity) {
+		if world == nil || world.Id() != removedFrom.Id() {
+			return
+		}
+		unsync(removedFrom.Entry(entity))
+	})
 }
 
+var (
+	// registration is incremented by every call to UseEsync, the router callbacks of earlier
+	// calls do nothing, as the router has no way to remove them.
+	registration atomic.Int64
+
+	hookedWorlds      = map[donburi.WorldId]bool{}
+	hookedWorldsMutex sync.Mutex
+)
+
 // AddNetworkFilter accepts a callback that can be used to filter out entities that gets included in the snapshots
 // sent to clients. By returning false in this filter function, the entity will be excluded.
 func AddNetworkFilter(filter func(client *router.NetworkClient, entry *donburi.Entry) bool) {
@@ -50,7 +100,7 @@ type SyncOption func(*donburi.Entity) []donburi.IComponentType
 // on the client-side.
 //
 // It is assumed that these components have been registered beforehand using
-// [esync.RegisterComponent] and [esync.WithInterpFn].
+// [esync.RegisterComponent] and [esync.WithLerpFn].
 func WithInterp(components ...donburi.IComponentType) SyncOption {
 	return func(entity *donburi.Entity) []donburi.IComponentType {
 		entry := world.Entry(*entity)
@@ -73,18 +123,11 @@ func WithInterp(components ...donburi.IComponentType) SyncOption {
 // Optionally you may provide [WithInterp] with a list of components as well to mark
 // those components for interpolation as well as network synchronization. This assumes
 // that these components have already been registered for interpolation beforehand
-// using [esync.RegisterComponent] and [esync.WithInterpFn].
+// using [esync.RegisterComponent] and [esync.WithLerpFn].
 //
 // > Components that are passed using [WithInterp] do not need to be passed again.
 func NetworkSync(world donburi.World, entity *donburi.Entity, components ...any) error {
-	// Increments the Network ID counter to prevent reusing the ids
-	NetworkIdCounter.Add(1)
-
-	networkId := NetworkIdCounter.Load()
-
 	entry := world.Entry(*entity)
-	entry.AddComponent(esync.NetworkIdComponent)
-	esync.NetworkIdComponent.SetValue(entry, esync.NetworkId(networkId))
 
 	// Create a list of components to sync
 	var foundComponents []donburi.IComponentType
@@ -101,8 +144,25 @@ func NetworkSync(world donburi.World, entity *donburi.Entity, components ...any)
 		}
 	}
 
+	// The network ID is replicated like any other component.
 	foundComponents = append(foundComponents, esync.NetworkIdComponent)
 
+	// Entities that are already synced keep their network ID.
+	syncEntMtx.RLock()
+	_, synced := syncEntities[*entity]
+	syncEntMtx.RUnlock()
+
+	networkId := esync.GetNetworkId(entry)
+	if !synced || networkId == nil {
+		id, err := NetworkIds.Allocate()
+		if err != nil {
+			return err
+		}
+		networkId = &id
+	}
+
+	esync.SetNetworkId(entry, *networkId)
+
 	syncEntMtx.Lock()
 	defer syncEntMtx.Unlock()
 	syncEntities[*entity] = foundComponents
@@ -114,24 +174,40 @@ var syncMutex sync.Mutex
 
 // DoSync should be called by the server and will build world state and then attempt to network it out to all the peers.
 // This is done by serializing all the components of the entity, and preparing a network bundle for the clients.
+// The messages are queued for each client, so a slow client never holds up the others, and a client that
+// is disconnected because its queue is full does not keep the others from being synced.
 func DoSync() error {
-	errs, _ := errgroup.WithContext(context.Background())
-
 	syncMutex.Lock()
 	defer syncMutex.Unlock()
 
+	despawn := takeDespawned()
+
+	var errs []error
 	for _, client := range router.Peers() {
-		snapshot := buildSnapshot(client, world)
-		errs.Go(func() error {
-			err := client.SendMessage(snapshot)
-			return err
-		})
+		// The components can only be encoded once the versions the client understands are known,
+		// it has no replicas to despawn before its first snapshot either.
+		if _, ok := client.Manifest(esync.ManifestName); !ok {
+			continue
+		}
+
+		if len(despawn.Ids) > 0 {
+			err := client.SendMessage(despawn)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+
+		err := client.SendMessage(buildSnapshot(client, world))
+		if err != nil {
+			errs = append(errs, err)
+		}
 	}
 
-	return errs.Wait()
+	return errors.Join(errs...)
 }
 
-func buildEntityState(entry *donburi.Entry) (esync.EntityState, error) {
+func buildEntityState(entry *donburi.Entry, versions map[uint]uint) (esync.EntityState, error) {
 	s := donburi.GetComponents(entry)
 
 	componentMap := make(esync.EntityState)
@@ -156,7 +232,7 @@ func buildEntityState(entry *donburi.Entry) (esync.EntityState, error) {
 		}
 
 		id := esync.Mapper.LookupId(t)
-		serializedComponent, err := esync.Mapper.Serialize(ecsComponent)
+		serializedComponent, err := esync.Mapper.SerializeVersion(ecsComponent, versions[id])
 		if err != nil {
 			return nil, err
 		}
@@ -170,6 +246,8 @@ func buildEntityState(entry *donburi.Entry) (esync.EntityState, error) {
 func buildSnapshot(client *router.NetworkClient, world donburi.World) esync.WorldSnapshot {
 	state := esync.WorldSnapshot([]esync.SerializedEntity{})
 
+	versions, _ := client.Manifest(esync.ManifestName)
+
 	stateMtx.Lock()
 	defer stateMtx.Unlock()
 	esync.NetworkEntityQuery.Each(world, func(entry *donburi.Entry) {
@@ -182,7 +260,7 @@ func buildSnapshot(client *router.NetworkClient, world donburi.World) esync.Worl
 			}
 		}
 
-		componentMap, err := buildEntityState(entry)
+		componentMap, err := buildEntityState(entry, versions)
 		if err != nil {
 			return
 		}
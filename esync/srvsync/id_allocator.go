@@ -0,0 +1,118 @@
+package srvsync
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/leap-fish/necs/esync"
+)
+
+var ErrNetworkIdsExhausted = errors.New("network ids exhausted")
+
+// minFreeIds is the number of released indices kept back before any of them are reused.
+// Reusing the least recently released index only once enough are free makes it unlikely
+// that a client still holds a reference to the previous generation.
+const minFreeIds = 1024
+
+// IdAllocator hands out network IDs and recycles the indices of released IDs.
+// Every time an index is reused its generation is incremented, so stale references to
+// the previous entity using the index can be told apart from the new one.
+type IdAllocator struct {
+	mutex sync.Mutex
+
+	// generations holds the current generation of every index handed out so far,
+	// alive whether the index is currently in use.
+	generations []uint8
+	alive       []bool
+	free        freeQueue
+}
+
+// NewIdAllocator creates an allocator, the index 0 is reserved so no ID is ever 0.
+func NewIdAllocator() *IdAllocator {
+	return &IdAllocator{
+		generations: []uint8{0},
+		alive:       []bool{true},
+	}
+}
+
+// Allocate returns an unused network ID.
+func (a *IdAllocator) Allocate() (esync.NetworkId, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.free.len > minFreeIds || (a.free.len > 0 && len(a.generations) > esync.MaxNetworkIdIndex) {
+		index := a.free.pop()
+		a.alive[index] = true
+		return esync.NewNetworkId(index, a.generations[index]), nil
+	}
+
+	index := uint32(len(a.generations))
+	if index > esync.MaxNetworkIdIndex {
+		return 0, ErrNetworkIdsExhausted
+	}
+
+	a.generations = append(a.generations, 0)
+	a.alive = append(a.alive, true)
+
+	return esync.NewNetworkId(index, 0), nil
+}
+
+// Release makes the index of the ID available for reuse with the next generation.
+// Releasing an ID that is not in use, or that belongs to an older generation, does nothing.
+func (a *IdAllocator) Release(id esync.NetworkId) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	index := id.Index()
+	if index == 0 || int(index) >= len(a.generations) {
+		return
+	}
+	if !a.alive[index] || a.generations[index] != id.Generation() {
+		return
+	}
+
+	a.alive[index] = false
+	a.generations[index]++
+	a.free.push(index)
+}
+
+// Alive returns true if the ID is currently allocated.
+func (a *IdAllocator) Alive(id esync.NetworkId) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	index := id.Index()
+	if int(index) >= len(a.generations) {
+		return false
+	}
+
+	return a.alive[index] && a.generations[index] == id.Generation()
+}
+
+// freeQueue is a ring buffer of released indices, the least recently released index is
+// reused first. Unlike re-slicing a slice, the memory of reused indices is reused as well.
+type freeQueue struct {
+	indices []uint32
+	head    int
+	len     int
+}
+
+func (q *freeQueue) push(index uint32) {
+	if q.len == len(q.indices) {
+		indices := make([]uint32, max(minFreeIds, len(q.indices)*2))
+		n := copy(indices, q.indices[q.head:])
+		copy(indices[n:], q.indices[:q.head])
+		q.indices, q.head = indices, 0
+	}
+
+	q.indices[(q.head+q.len)%len(q.indices)] = index
+	q.len++
+}
+
+// pop removes the oldest index, the queue must not be empty.
+func (q *freeQueue) pop() uint32 {
+	index := q.indices[q.head]
+	q.head = (q.head + 1) % len(q.indices)
+	q.len--
+	return index
+}
@@ -0,0 +1,68 @@
+package srvsync_test
+
+import (
+	"testing"
+
+	"github.com/leap-fish/necs/esync"
+	"github.com/leap-fish/necs/esync/srvsync"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdAllocator_Recycle(t *testing.T) {
+	allocator := srvsync.NewIdAllocator()
+
+	var ids []esync.NetworkId
+	for i := 0; i < 2000; i++ {
+		id, err := allocator.Allocate()
+		assert.Nil(t, err)
+		assert.NotZero(t, id)
+		ids = append(ids, id)
+	}
+	assert.Equal(t, esync.NewNetworkId(1, 0), ids[0])
+
+	for _, id := range ids {
+		allocator.Release(id)
+		assert.False(t, allocator.Alive(id))
+	}
+	// Double release is ignored.
+	allocator.Release(ids[0])
+
+	// The first released index is reused once enough indices are free, with the next generation.
+	recycled, err := allocator.Allocate()
+	assert.Nil(t, err)
+	assert.Equal(t, ids[0].Index(), recycled.Index())
+	assert.Equal(t, uint8(1), recycled.Generation())
+	assert.NotEqual(t, ids[0], recycled)
+	assert.True(t, allocator.Alive(recycled))
+
+	// Releasing the stale ID must not free the recycled one.
+	allocator.Release(ids[0])
+	assert.True(t, allocator.Alive(recycled))
+}
+
+func TestIdAllocator_RecycleOrder(t *testing.T) {
+	allocator := srvsync.NewIdAllocator()
+
+	var ids []esync.NetworkId
+	for i := 0; i < 1500; i++ {
+		id, err := allocator.Allocate()
+		assert.Nil(t, err)
+		ids = append(ids, id)
+	}
+
+	var free []uint32
+	for _, id := range ids {
+		allocator.Release(id)
+		free = append(free, id.Index())
+	}
+
+	// Indices are always reused in the order they were released, also once the queue wraps around.
+	for i := 0; i < 5000; i++ {
+		id, err := allocator.Allocate()
+		assert.Nil(t, err)
+		assert.Equal(t, free[0], id.Index())
+
+		free = append(free[1:], id.Index())
+		allocator.Release(id)
+	}
+}
@@ -0,0 +1,192 @@
+package srvsync_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leap-fish/necs/esync"
+	"github.com/leap-fish/necs/esync/clisync"
+	"github.com/leap-fish/necs/esync/srvsync"
+	"github.com/leap-fish/necs/netsim"
+	"github.com/leap-fish/necs/router"
+	"github.com/leap-fish/necs/transports"
+	"github.com/stretchr/testify/assert"
+	"github.com/yohamta/donburi"
+)
+
+type Score struct {
+	Points int
+}
+
+var ScoreComponent = donburi.NewComponentType[Score]()
+
+func init() {
+	_ = esync.RegisterComponent(300, Score{}, ScoreComponent)
+}
+
+func TestLoopback_SyncAndDespawn(t *testing.T) {
+	router.ResetRouter()
+
+	serverWorld := donburi.NewWorld()
+	clientWorld := donburi.NewWorld()
+	srvsync.UseEsync(serverWorld)
+	clisync.RegisterClient(clientWorld)
+
+	loopback := transports.NewLoopbackTransport()
+	client := loopback.Connect()
+	defer client.Close(router.CloseNormal, "")
+	loopback.Pump()
+
+	entity := serverWorld.Create(ScoreComponent)
+	ScoreComponent.SetValue(serverWorld.Entry(entity), Score{Points: 7})
+	assert.Nil(t, srvsync.NetworkSync(serverWorld, &entity, ScoreComponent))
+	id := *esync.GetNetworkId(serverWorld.Entry(entity))
+
+	assert.Nil(t, srvsync.DoSync())
+	loopback.Pump()
+
+	replica, ok := esync.LookupEntity(clientWorld, id)
+	assert.True(t, ok)
+	assert.Equal(t, Score{Points: 7}, ScoreComponent.GetValue(clientWorld.Entry(replica)))
+
+	assert.Nil(t, srvsync.NetworkDespawn(entity))
+	assert.Nil(t, srvsync.DoSync())
+	loopback.Pump()
+
+	_, ok = esync.LookupEntity(clientWorld, id)
+	assert.False(t, ok)
+	assert.False(t, clientWorld.Valid(replica))
+}
+
+func TestLoopback_SimulatedNetwork(t *testing.T) {
+	router.ResetRouter()
+
+	// 150ms round trip time with jitter and 5% snapshot loss in both directions.
+	sim := netsim.NewManual(1)
+	defer sim.Close()
+	sim.SetConditions(netsim.Conditions{Latency: 75 * time.Millisecond, Jitter: 10 * time.Millisecond, Loss: 0.05})
+	sim.Install()
+
+	serverWorld := donburi.NewWorld()
+	clientWorld := donburi.NewWorld()
+	srvsync.UseEsync(serverWorld)
+	clisync.RegisterClient(clientWorld)
+
+	loopback := transports.NewLoopbackTransport()
+	client := loopback.Connect()
+	defer client.Close(router.CloseNormal, "")
+
+	entity := serverWorld.Create(ScoreComponent)
+	assert.Nil(t, srvsync.NetworkSync(serverWorld, &entity, ScoreComponent))
+	id := *esync.GetNetworkId(serverWorld.Entry(entity))
+
+	// Send a snapshot every 50ms while the score keeps changing.
+	for tick := 0; tick < 20; tick++ {
+		ScoreComponent.SetValue(serverWorld.Entry(entity), Score{Points: tick})
+		assert.Nil(t, srvsync.DoSync())
+
+		sim.Advance(50 * time.Millisecond)
+		loopback.Pump()
+	}
+
+	// The snapshots are still on their way.
+	replica, ok := esync.LookupEntity(clientWorld, id)
+	assert.True(t, ok)
+	assert.Less(t, ScoreComponent.GetValue(clientWorld.Entry(replica)).Points, 19)
+
+	sim.Advance(time.Second)
+	loopback.Pump()
+	assert.Equal(t, Score{Points: 19}, ScoreComponent.GetValue(clientWorld.Entry(replica)))
+}
+
+func TestLoopback_ResumeSession(t *testing.T) {
+	router.ResetRouter()
+	router.EnableSessions(time.Minute)
+
+	serverWorld := donburi.NewWorld()
+	clientWorld := donburi.NewWorld()
+	srvsync.UseEsync(serverWorld)
+	clisync.RegisterClient(clientWorld)
+
+	loopback := transports.NewLoopbackTransport()
+	client := loopback.Connect()
+	loopback.Pump()
+
+	removed := serverWorld.Create(ScoreComponent)
+	assert.Nil(t, srvsync.NetworkSync(serverWorld, &removed, ScoreComponent))
+	removedId := *esync.GetNetworkId(serverWorld.Entry(removed))
+	assert.Nil(t, srvsync.DoSync())
+	loopback.Pump()
+
+	token := client.Client().SessionToken()
+	assert.Nil(t, client.Client().Disconnect("connection lost"))
+	loopback.Pump()
+
+	// The world changes while the client is away.
+	assert.Nil(t, srvsync.NetworkDespawn(removed))
+	spawned := serverWorld.Create(ScoreComponent)
+	ScoreComponent.SetValue(serverWorld.Entry(spawned), Score{Points: 3})
+	assert.Nil(t, srvsync.NetworkSync(serverWorld, &spawned, ScoreComponent))
+	spawnedId := *esync.GetNetworkId(serverWorld.Entry(spawned))
+	assert.Nil(t, srvsync.DoSync())
+
+	// The client is sent the full state as soon as it resumes its session.
+	client = loopback.Reconnect(token)
+	defer client.Close(router.CloseNormal, "")
+	loopback.Pump()
+
+	_, ok := esync.LookupEntity(clientWorld, removedId)
+	assert.False(t, ok)
+	replica, ok := esync.LookupEntity(clientWorld, spawnedId)
+	assert.True(t, ok)
+	assert.Equal(t, Score{Points: 3}, ScoreComponent.GetValue(clientWorld.Entry(replica)))
+
+	assert.Nil(t, srvsync.NetworkDespawn(spawned))
+}
+
+func TestLoopback_UseEsyncAgain(t *testing.T) {
+	router.ResetRouter()
+	router.EnableSessions(time.Minute)
+
+	serverWorld := donburi.NewWorld()
+	clientWorld := donburi.NewWorld()
+	srvsync.UseEsync(serverWorld)
+	srvsync.UseEsync(serverWorld)
+	clisync.RegisterClient(clientWorld)
+
+	snapshots := 0
+	router.On[esync.WorldSnapshot](func(sender *router.NetworkClient, message esync.WorldSnapshot) {
+		snapshots++
+	})
+
+	loopback := transports.NewLoopbackTransport()
+	client := loopback.Connect()
+	loopback.Pump()
+
+	token := client.Client().SessionToken()
+	assert.Nil(t, client.Client().Disconnect("connection lost"))
+	loopback.Pump()
+
+	// Only the latest registration sends a snapshot to the resumed client.
+	client = loopback.Reconnect(token)
+	defer client.Close(router.CloseNormal, "")
+	loopback.Pump()
+	assert.Equal(t, 1, snapshots)
+
+	// Removing an entity of a world that is no longer used does not unsync the current one.
+	entity := serverWorld.Create(ScoreComponent)
+	assert.Nil(t, srvsync.NetworkSync(serverWorld, &entity, ScoreComponent))
+	id := *esync.GetNetworkId(serverWorld.Entry(entity))
+
+	oldWorld := donburi.NewWorld()
+	srvsync.UseEsync(oldWorld)
+	srvsync.UseEsync(serverWorld)
+	oldWorld.Remove(oldWorld.Create(ScoreComponent))
+
+	assert.Nil(t, srvsync.DoSync())
+	loopback.Pump()
+	_, ok := esync.LookupEntity(clientWorld, id)
+	assert.True(t, ok)
+
+	assert.Nil(t, srvsync.NetworkDespawn(entity))
+}
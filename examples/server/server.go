@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
 
 	"github.com/leap-fish/necs/examples/shared"
 	"github.com/leap-fish/necs/router"
@@ -27,8 +30,13 @@ func main() {
 		log.Printf("Message Error: %s", err.Error())
 	})
 
+	// Interrupting the server closes the connections of the clients, and waits for their
+	// disconnect callbacks before exiting.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	server := transports.NewWsServerTransport(7373, "", nil)
-	err := server.Start()
+	err := server.Start(ctx)
 	if err != nil {
 		log.Fatalf("Unable to dial: %s", err)
 	}
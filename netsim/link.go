@@ -0,0 +1,198 @@
+package netsim
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/leap-fish/necs/router"
+)
+
+var ErrLinkClosed = errors.New("simulated link is closed")
+
+// Link is a simulated connection, it delays and drops the messages sent to the peer according
+// to its conditions before writing them to the wrapped connection.
+type Link struct {
+	sim  *Simulator
+	conn router.Conn
+
+	// Guarded by the mutex of the simulator.
+	conditions *Conditions
+	closed     bool
+	// lastAt is when the last ordered message is delivered, later messages are never delivered before it.
+	lastAt time.Time
+	// sequenced numbers the sequenced messages sent, sequencedDelivered is the newest one written
+	// to the wrapped connection. Older ones arriving after it are dropped, like the transports do.
+	sequenced          uint64
+	sequencedDelivered uint64
+	// busyUntil is when the link has finished sending the queued messages, for the bandwidth limit.
+	busyUntil time.Time
+}
+
+// Conn returns the wrapped connection.
+func (l *Link) Conn() router.Conn {
+	return l.conn
+}
+
+// SetConditions sets the conditions of this link, overriding those of the simulator.
+// It can be changed at any time and applies to messages sent afterwards.
+func (l *Link) SetConditions(conditions Conditions) {
+	l.sim.mutex.Lock()
+	defer l.sim.mutex.Unlock()
+
+	l.conditions = &conditions
+}
+
+// Conditions returns the conditions that apply to the link.
+func (l *Link) Conditions() Conditions {
+	l.sim.mutex.Lock()
+	defer l.sim.mutex.Unlock()
+
+	return l.effectiveConditions()
+}
+
+func (l *Link) effectiveConditions() Conditions {
+	if l.conditions != nil {
+		return *l.conditions
+	}
+	if l.sim.outboundConditions != nil && l.Outbound() {
+		return *l.sim.outboundConditions
+	}
+	return l.sim.conditions
+}
+
+// Write sends the payload over the [router.ReliableOrdered] channel.
+func (l *Link) Write(ctx context.Context, payload []byte) error {
+	return l.WriteChannel(ctx, router.ReliableOrdered, payload)
+}
+
+// WriteChannel schedules the payload to be written to the wrapped connection, according to the
+// conditions of the link.
+func (l *Link) WriteChannel(ctx context.Context, channel router.Channel, payload []byte) error {
+	sim := l.sim
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+
+	if l.closed {
+		return ErrLinkClosed
+	}
+
+	conditions := l.effectiveConditions()
+	unreliable := channel == router.Unreliable || channel == router.UnreliableSequenced
+	now := sim.clock()
+
+	// The message is sent even if it gets lost, so it still takes up bandwidth.
+	sent := now
+	if conditions.Bandwidth > 0 {
+		l.busyUntil = maxTime(l.busyUntil, now).Add(time.Duration(len(payload)) * time.Second / time.Duration(conditions.Bandwidth))
+		sent = l.busyUntil
+	}
+
+	if unreliable && sim.random.Float64() < conditions.Loss {
+		return nil
+	}
+
+	copies := 1
+	if unreliable && sim.random.Float64() < conditions.Duplicate {
+		copies = 2
+	}
+
+	var sequence uint64
+	if channel == router.UnreliableSequenced {
+		l.sequenced++
+		sequence = l.sequenced
+	}
+
+	// The payload might be reused by the caller after writing.
+	payload = append([]byte(nil), payload...)
+	for i := 0; i < copies; i++ {
+		at := sent.Add(l.delay(conditions))
+		if !unreliable || sim.random.Float64() >= conditions.Reorder {
+			at = maxTime(at, l.lastAt)
+			l.lastAt = at
+		}
+
+		sim.schedule(&delivery{link: l, at: at, channel: channel, sequence: sequence, payload: payload})
+	}
+	return nil
+}
+
+// delay returns the latency with a random jitter, the mutex of the simulator must be held.
+func (l *Link) delay(conditions Conditions) time.Duration {
+	delay := conditions.Latency
+	if conditions.Jitter > 0 {
+		delay += time.Duration(l.sim.random.Int63n(int64(conditions.Jitter)*2+1)) - conditions.Jitter
+	}
+	return max(delay, 0)
+}
+
+// write writes a due message to the wrapped connection. Messages that are due after the
+// connection closed are dropped, like the messages of a connection that is lost, and so are
+// sequenced messages that were overtaken by a newer one.
+func (l *Link) write(channel router.Channel, sequence uint64, payload []byte) {
+	l.sim.mutex.Lock()
+	closed := l.closed
+	stale := false
+	if channel == router.UnreliableSequenced {
+		stale = sequence <= l.sequencedDelivered
+		l.sequencedDelivered = max(l.sequencedDelivered, sequence)
+	}
+	l.sim.mutex.Unlock()
+	if closed || stale || l.conn.Context().Err() != nil {
+		return
+	}
+
+	var err error
+	if writer, ok := l.conn.(router.ChannelWriter); ok {
+		err = writer.WriteChannel(context.Background(), channel, payload)
+	} else {
+		err = l.conn.Write(context.Background(), payload)
+	}
+	if err == nil {
+		return
+	}
+
+	// The peer may have disconnected in the meantime, its client is not created again.
+	if client, ok := router.LookupClient(l.conn); ok {
+		router.ReportError(client, err)
+	}
+}
+
+// Close closes the wrapped connection right away, messages that have not been delivered yet are dropped.
+func (l *Link) Close(status router.CloseStatus, reason string) error {
+	l.sim.mutex.Lock()
+	l.closed = true
+	l.sim.mutex.Unlock()
+
+	return l.conn.Close(status, reason)
+}
+
+// RemoteAddr returns the address of the peer of the wrapped connection.
+func (l *Link) RemoteAddr() net.Addr {
+	return l.conn.RemoteAddr()
+}
+
+// Context returns the context of the wrapped connection.
+func (l *Link) Context() context.Context {
+	return l.conn.Context()
+}
+
+// Outbound returns whether the wrapped connection is outbound, see [router.OutboundConn].
+func (l *Link) Outbound() bool {
+	conn, ok := l.conn.(router.OutboundConn)
+	return ok && conn.Outbound()
+}
+
+// Synchronous returns whether the wrapped connection is synchronous, see [router.SynchronousConn].
+func (l *Link) Synchronous() bool {
+	conn, ok := l.conn.(router.SynchronousConn)
+	return ok && conn.Synchronous()
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
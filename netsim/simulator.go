@@ -0,0 +1,259 @@
+// Package netsim simulates network conditions such as latency, jitter, packet loss and limited
+// bandwidth on top of any transport.
+//
+// The simulator wraps the connections of the router, so everything sent to a peer goes through
+// a simulated [Link]. Only outgoing messages are simulated, received messages are handled as
+// soon as the transport delivers them. A server therefore only simulates the downstream of
+// its clients, the upstream of a client running in another process is only simulated if that
+// client installs a simulator as well. When the server and clients run in the same process,
+// such as with the loopback transport, both directions are simulated, see
+// [Simulator.SetOutboundConditions].
+//
+//	sim := netsim.New(1)
+//	sim.SetConditions(netsim.Conditions{Latency: 75 * time.Millisecond, Jitter: 10 * time.Millisecond, Loss: 0.05})
+//	sim.Install()
+//	defer sim.Close()
+package netsim
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/leap-fish/necs/router"
+)
+
+// Conditions describes the simulated network conditions of the messages sent over a link.
+//
+// Loss, duplication and reordering only apply to messages sent over the unreliable channels.
+// Reliable channels are only delayed, like a reliable transport hides lost packets by sending
+// them again.
+type Conditions struct {
+	// Latency is the one-way delay of every message, half of the round trip time.
+	Latency time.Duration
+	// Jitter is the largest random deviation from the latency, in both directions.
+	Jitter time.Duration
+	// Loss is the chance between 0 and 1 that a message is dropped.
+	Loss float64
+	// Duplicate is the chance between 0 and 1 that a message is delivered twice.
+	Duplicate float64
+	// Reorder is the chance between 0 and 1 that a message may overtake earlier messages.
+	// Other messages are delivered in the order they were sent, even with jitter. Sequenced
+	// messages that were overtaken, or already delivered, are dropped like the transports do.
+	Reorder float64
+	// Bandwidth limits the link to the given number of bytes per second, 0 is unlimited.
+	// Messages queue up behind each other once the limit is reached.
+	Bandwidth int
+}
+
+// Simulator schedules the messages of all simulated links.
+type Simulator struct {
+	mutex  sync.Mutex
+	random *rand.Rand
+
+	conditions         Conditions
+	outboundConditions *Conditions
+
+	manual  bool
+	now     time.Time
+	queue   deliveryQueue
+	counter uint64
+	wake    chan struct{}
+	done    chan struct{}
+	closed  bool
+}
+
+// New creates a simulator that delivers messages in real time. The seed makes the random
+// decisions of the simulator repeatable.
+func New(seed int64) *Simulator {
+	s := newSimulator(seed)
+	go s.run()
+	return s
+}
+
+// NewManual creates a simulator with a manual clock, messages are only delivered when time is
+// advanced using [Simulator.Advance]. Together with the loopback transport this makes tests
+// fully deterministic.
+func NewManual(seed int64) *Simulator {
+	s := newSimulator(seed)
+	s.manual = true
+	s.now = time.Unix(0, 0)
+	return s
+}
+
+func newSimulator(seed int64) *Simulator {
+	return &Simulator{
+		random: rand.New(rand.NewSource(seed)),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// Install makes the router send through the simulator, it applies to clients that connect
+// afterwards. Use [router.SetConnWrapper] with nil to stop simulating.
+func (s *Simulator) Install() {
+	router.SetConnWrapper(s.Wrap)
+}
+
+// Wrap returns a simulated link for the connection.
+func (s *Simulator) Wrap(conn router.Conn) router.Conn {
+	return &Link{sim: s, conn: conn}
+}
+
+// SetConditions sets the conditions of all links that have no conditions of their own.
+// It can be changed at any time and applies to messages sent afterwards.
+func (s *Simulator) SetConditions(conditions Conditions) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.conditions = conditions
+}
+
+// SetOutboundConditions sets the conditions of outbound links that have no conditions of their
+// own, see [router.OutboundConn]. When the server and clients run in the same process, these are
+// the links from the clients to the server, while [Simulator.SetConditions] applies to the
+// links from the server to the clients.
+func (s *Simulator) SetOutboundConditions(conditions Conditions) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.outboundConditions = &conditions
+}
+
+// LinkOf returns the simulated link of the client, if its connection is simulated.
+// This is used to set the conditions of a single client, see [Link.SetConditions].
+func LinkOf(client *router.NetworkClient) (*Link, bool) {
+	link, ok := client.Conn().(*Link)
+	return link, ok
+}
+
+// Advance moves the clock of a manual simulator forward, and delivers the messages that are due
+// on the calling goroutine.
+func (s *Simulator) Advance(d time.Duration) {
+	s.mutex.Lock()
+	if !s.manual {
+		s.mutex.Unlock()
+		panic("netsim: Advance called on a real time simulator")
+	}
+	s.now = s.now.Add(d)
+	due := s.takeDue(s.now)
+	s.mutex.Unlock()
+
+	for _, delivery := range due {
+		delivery.deliver()
+	}
+}
+
+// Close stops the simulator, messages that have not been delivered yet are dropped.
+func (s *Simulator) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.queue = nil
+	close(s.done)
+}
+
+func (s *Simulator) clock() time.Time {
+	if s.manual {
+		return s.now
+	}
+	return time.Now()
+}
+
+// schedule queues the delivery, the mutex must be held.
+func (s *Simulator) schedule(delivery *delivery) {
+	if s.closed {
+		return
+	}
+
+	s.counter++
+	delivery.order = s.counter
+	heap.Push(&s.queue, delivery)
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// takeDue removes the deliveries that are due at the given time, the mutex must be held.
+func (s *Simulator) takeDue(now time.Time) []*delivery {
+	var due []*delivery
+	for len(s.queue) > 0 && !s.queue[0].at.After(now) {
+		due = append(due, heap.Pop(&s.queue).(*delivery))
+	}
+	return due
+}
+
+// run delivers the messages of a real time simulator when they are due.
+func (s *Simulator) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.mutex.Lock()
+		due := s.takeDue(time.Now())
+		wait := time.Hour
+		if len(s.queue) > 0 {
+			wait = time.Until(s.queue[0].at)
+		}
+		s.mutex.Unlock()
+
+		for _, delivery := range due {
+			delivery.deliver()
+		}
+
+		timer.Reset(wait)
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// delivery is a message that is written to the wrapped connection once it is due.
+type delivery struct {
+	link    *Link
+	at      time.Time
+	order   uint64
+	channel router.Channel
+	// sequence numbers the sequenced messages of the link, it is 0 for the other channels.
+	sequence uint64
+	payload  []byte
+}
+
+func (d *delivery) deliver() {
+	d.link.write(d.channel, d.sequence, d.payload)
+}
+
+// deliveryQueue is a heap of deliveries ordered by when they are due, and then by the order
+// they were scheduled in.
+type deliveryQueue []*delivery
+
+func (q deliveryQueue) Len() int { return len(q) }
+
+func (q deliveryQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].order < q[j].order
+	}
+	return q[i].at.Before(q[j].at)
+}
+
+func (q deliveryQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *deliveryQueue) Push(x any) { *q = append(*q, x.(*delivery)) }
+
+func (q *deliveryQueue) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return last
+}
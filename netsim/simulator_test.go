@@ -0,0 +1,210 @@
+package netsim_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leap-fish/necs/netsim"
+	"github.com/leap-fish/necs/router"
+	"github.com/leap-fish/necs/transports"
+	"github.com/stretchr/testify/assert"
+)
+
+type Reliable struct {
+	N int
+}
+
+type Snapshot struct {
+	N int
+}
+
+// setup connects a loopback client through a manual simulator, and records the messages
+// received by the client.
+func setup(t *testing.T, conditions netsim.Conditions) (*netsim.Simulator, *transports.LoopbackTransport, *router.NetworkClient, *[]int) {
+	router.ResetRouter()
+
+	var received []int
+	router.On[Reliable](func(sender *router.NetworkClient, message Reliable) {
+		received = append(received, message.N)
+	})
+	router.On[Snapshot](func(sender *router.NetworkClient, message Snapshot) {
+		received = append(received, message.N)
+	}, router.WithChannel(router.UnreliableSequenced))
+
+	sim := netsim.NewManual(1)
+	sim.SetConditions(conditions)
+	sim.Install()
+	t.Cleanup(sim.Close)
+
+	loopback := transports.NewLoopbackTransport()
+	loopback.Connect()
+	// Deliver the schema manifests exchanged when connecting.
+	loopback.Pump()
+	sim.Advance(time.Second)
+	loopback.Pump()
+
+	peers := router.Peers()
+	assert.Len(t, peers, 1)
+	return sim, loopback, peers[0], &received
+}
+
+func TestSimulator_Latency(t *testing.T) {
+	sim, loopback, server, received := setup(t, netsim.Conditions{Latency: 50 * time.Millisecond})
+
+	assert.Nil(t, server.SendMessage(Reliable{N: 1}))
+	sim.Advance(49 * time.Millisecond)
+	loopback.Pump()
+	assert.Empty(t, *received)
+
+	sim.Advance(time.Millisecond)
+	loopback.Pump()
+	assert.Equal(t, []int{1}, *received)
+}
+
+func TestSimulator_JitterKeepsReliableOrder(t *testing.T) {
+	sim, loopback, server, received := setup(t, netsim.Conditions{
+		Latency: 50 * time.Millisecond,
+		Jitter:  40 * time.Millisecond,
+		Loss:    0.5,
+		Reorder: 1,
+	})
+
+	var expected []int
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, server.SendMessage(Reliable{N: i}))
+		expected = append(expected, i)
+		sim.Advance(time.Millisecond)
+	}
+	sim.Advance(time.Second)
+	loopback.Pump()
+
+	// Reliable messages are never lost or reordered.
+	assert.Equal(t, expected, *received)
+}
+
+func TestSimulator_Loss(t *testing.T) {
+	sim, loopback, server, received := setup(t, netsim.Conditions{Loss: 0.5})
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, server.SendMessage(Snapshot{N: i}))
+	}
+	sim.Advance(time.Millisecond)
+	loopback.Pump()
+
+	assert.InDelta(t, 500, len(*received), 100)
+}
+
+func TestSimulator_Bandwidth(t *testing.T) {
+	sim, loopback, server, received := setup(t, netsim.Conditions{Bandwidth: 1000})
+
+	payload, err := router.Serialize(Reliable{})
+	assert.Nil(t, err)
+
+	// Each message takes len(payload) milliseconds to send at 1000 bytes per second.
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, server.SendMessage(Reliable{N: i}))
+	}
+	sim.Advance(time.Duration(len(payload)*5) * time.Millisecond)
+	loopback.Pump()
+	assert.Len(t, *received, 5)
+
+	sim.Advance(time.Duration(len(payload)*5) * time.Millisecond)
+	loopback.Pump()
+	assert.Len(t, *received, 10)
+}
+
+func TestSimulator_PerClientConditions(t *testing.T) {
+	sim, loopback, server, received := setup(t, netsim.Conditions{})
+
+	link, ok := netsim.LinkOf(server)
+	assert.True(t, ok)
+	link.SetConditions(netsim.Conditions{Latency: time.Second})
+	assert.Equal(t, time.Second, link.Conditions().Latency)
+
+	assert.Nil(t, server.SendMessage(Reliable{N: 1}))
+	sim.Advance(time.Second - time.Millisecond)
+	loopback.Pump()
+	assert.Empty(t, *received)
+
+	sim.Advance(time.Millisecond)
+	loopback.Pump()
+	assert.Equal(t, []int{1}, *received)
+}
+
+func TestSimulator_RealTime(t *testing.T) {
+	router.ResetRouter()
+
+	received := make(chan int, 1)
+	router.On[Reliable](func(sender *router.NetworkClient, message Reliable) {
+		received <- message.N
+	})
+
+	sim := netsim.New(1)
+	defer sim.Close()
+	sim.SetConditions(netsim.Conditions{Latency: 20 * time.Millisecond})
+	sim.Install()
+
+	loopback := transports.NewLoopbackTransport()
+	loopback.Connect()
+
+	start := time.Now()
+	assert.Nil(t, router.Broadcast(Reliable{N: 1}))
+
+	deadline := time.After(time.Second * 5)
+	for {
+		loopback.Pump()
+		select {
+		case n := <-received:
+			assert.Equal(t, 1, n)
+			assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+			return
+		case <-deadline:
+			t.Fatal("timed out")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestSimulator_DisconnectWhileDelayed(t *testing.T) {
+	sim, loopback, server, _ := setup(t, netsim.Conditions{Latency: 50 * time.Millisecond})
+
+	var errs []error
+	router.OnError(func(sender *router.NetworkClient, err error) {
+		errs = append(errs, err)
+	})
+
+	// The client disconnects while the message is still on its way.
+	assert.Nil(t, server.SendMessage(Reliable{N: 1}))
+	link, _ := netsim.LinkOf(server)
+	assert.Nil(t, link.Conn().Close(router.CloseNormal, ""))
+	loopback.Pump()
+	assert.Empty(t, router.Peers())
+
+	sim.Advance(time.Second)
+	loopback.Pump()
+	assert.Empty(t, router.Peers())
+	assert.Empty(t, errs)
+}
+
+func TestSimulator_SequencedNeverReordered(t *testing.T) {
+	sim, loopback, server, received := setup(t, netsim.Conditions{
+		Latency:   50 * time.Millisecond,
+		Jitter:    40 * time.Millisecond,
+		Duplicate: 0.5,
+		Reorder:   1,
+	})
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, server.SendMessage(Snapshot{N: i}))
+		sim.Advance(time.Millisecond)
+		loopback.Pump()
+	}
+	sim.Advance(time.Second)
+	loopback.Pump()
+
+	// Snapshots that were overtaken are dropped, the newest one always arrives.
+	assert.NotEmpty(t, *received)
+	assert.Less(t, len(*received), 100)
+	assert.IsIncreasing(t, *received)
+	assert.Equal(t, 99, (*received)[len(*received)-1])
+}
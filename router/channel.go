@@ -0,0 +1,85 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Channel is the delivery guarantee used to send a message type.
+type Channel uint8
+
+const (
+	// ReliableOrdered messages always arrive, in the order they were sent. This is the default.
+	ReliableOrdered Channel = iota
+	// ReliableUnordered messages always arrive, but may overtake each other.
+	ReliableUnordered
+	// Unreliable messages may be lost, duplicated or arrive out of order.
+	Unreliable
+	// UnreliableSequenced messages may be lost, but are never delivered after a newer message
+	// of the same type: only the newest one matters, such as for world snapshots.
+	UnreliableSequenced
+)
+
+func (c Channel) String() string {
+	switch c {
+	case ReliableOrdered:
+		return "reliable-ordered"
+	case ReliableUnordered:
+		return "reliable-unordered"
+	case Unreliable:
+		return "unreliable"
+	case UnreliableSequenced:
+		return "unreliable-sequenced"
+	}
+	return fmt.Sprintf("channel(%d)", uint8(c))
+}
+
+// ChannelWriter can be implemented by the connections of transports that support the delivery
+// channels natively, such as datagram transports. Connections that do not implement it, such as
+// WebSockets, send everything over a single reliable ordered stream. Stale [UnreliableSequenced]
+// messages still waiting in the send queue of a client are replaced by newer ones, see [Coalesce].
+type ChannelWriter interface {
+	WriteChannel(ctx context.Context, channel Channel, payload []byte) error
+}
+
+// Option configures a message type when registering it with [On].
+type Option func(msgType reflect.Type)
+
+// WithChannel sends the message type over the given channel, see [SetChannel].
+func WithChannel(channel Channel) Option {
+	return func(msgType reflect.Type) {
+		setChannel(msgType, channel)
+	}
+}
+
+var (
+	channels      = make(map[reflect.Type]Channel)
+	channelsMutex sync.RWMutex
+)
+
+// SetChannel sets the channel the message type T is sent over. This is useful for message types
+// that are only sent and never registered using [On] on this side of the connection.
+func SetChannel[T any](channel Channel) {
+	setChannel(reflect.TypeOf((*T)(nil)).Elem(), channel)
+}
+
+// ChannelOf returns the channel the message is sent over.
+func ChannelOf(msg any) Channel {
+	return channelOf(reflect.TypeOf(msg))
+}
+
+func setChannel(msgType reflect.Type, channel Channel) {
+	channelsMutex.Lock()
+	defer channelsMutex.Unlock()
+
+	channels[msgType] = channel
+}
+
+func channelOf(msgType reflect.Type) Channel {
+	channelsMutex.RLock()
+	defer channelsMutex.RUnlock()
+
+	return channels[msgType]
+}
@@ -0,0 +1,22 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Snapshot struct {
+	Tick int
+}
+
+func TestChannel_RegisteredWithOn(t *testing.T) {
+	ResetRouter()
+
+	assert.Equal(t, ReliableOrdered, ChannelOf(Snapshot{}))
+	On[Snapshot](func(sender *NetworkClient, message Snapshot) {}, WithChannel(UnreliableSequenced))
+	assert.Equal(t, UnreliableSequenced, ChannelOf(Snapshot{}))
+
+	ResetRouter()
+	assert.Equal(t, ReliableOrdered, ChannelOf(Snapshot{}))
+}
@@ -0,0 +1,70 @@
+package router
+
+import (
+	"context"
+	"net"
+)
+
+// CloseStatus is the reason a connection is closed, the values match the WebSocket close codes.
+type CloseStatus uint16
+
+const (
+	CloseNormal          CloseStatus = 1000
+	CloseGoingAway       CloseStatus = 1001
+	ClosePolicyViolation CloseStatus = 1008
+	CloseInternalError   CloseStatus = 1011
+	CloseTryAgainLater   CloseStatus = 1013
+	// CloseUnauthorized is sent to peers that failed to authenticate, it is in the range of
+	// status codes that is reserved for applications.
+	CloseUnauthorized CloseStatus = 4001
+)
+
+// Conn is a connection to a peer, it is implemented by the transports.
+// Connections are used as map keys by the router, so they have to be comparable and
+// the same connection must always be passed as an equal value.
+type Conn interface {
+	// Write sends a single message to the peer.
+	Write(ctx context.Context, payload []byte) error
+	// Close closes the connection, transports that support it send the status and reason
+	// to the peer.
+	Close(status CloseStatus, reason string) error
+	// RemoteAddr returns the address of the peer.
+	RemoteAddr() net.Addr
+	// Context returns the context of the connection, which is canceled once the connection
+	// is closed.
+	Context() context.Context
+}
+
+// OutboundConn can be implemented by connections that this process opened to a server running
+// in the same process, such as the client end of a loopback connection. Such connections are
+// not peers of the server, so they are left out of [Peers] and [Broadcast].
+type OutboundConn interface {
+	Conn
+	Outbound() bool
+}
+
+// SynchronousConn can be implemented by connections whose events have to be handled
+// deterministically, such as in-memory connections. The connect, disconnect and error callbacks
+// of such connections are called synchronously instead of in new goroutines.
+type SynchronousConn interface {
+	Conn
+	Synchronous() bool
+}
+
+// IdentifiedConn can be implemented by connections that were authenticated by the transport,
+// such as during the WebSocket upgrade. The identity becomes the identity of the NetworkClient,
+// see [NetworkClient.Identity].
+type IdentifiedConn interface {
+	Conn
+	Identity() any
+}
+
+func outbound(conn Conn) bool {
+	c, ok := conn.(OutboundConn)
+	return ok && c.Outbound()
+}
+
+func synchronous(conn Conn) bool {
+	c, ok := conn.(SynchronousConn)
+	return ok && c.Synchronous()
+}
@@ -0,0 +1,194 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const DefaultHandshakeTimeout = time.Second * 5
+
+// maxHandshakeBacklog is the number of other messages a peer can send before its handshake,
+// they are processed once the handshake succeeded.
+const maxHandshakeBacklog = 32
+
+var (
+	ErrHandshakeTimeout  = errors.New("handshake timed out")
+	ErrHandshakeRejected = errors.New("handshake rejected")
+	ErrHandshakeRequired = errors.New("handshake required")
+)
+
+// handshake is the handshake peers have to complete before they are connected, see Handshake.
+type handshake struct {
+	msgType reflect.Type
+	timeout time.Duration
+	verify  func(sender *NetworkClient, message any) (any, error)
+}
+
+var (
+	currentHandshake *handshake
+	handshakeMutex   sync.RWMutex
+)
+
+// Handshake requires peers to send a message of type T within the timeout after connecting,
+// before they are connected. The message is passed to verify, which returns the identity of
+// the peer, see [NetworkClient.Identity]. A nil identity keeps the identity set by the transport.
+//
+// Until the handshake succeeded, the connect callbacks are not called, the peer is left out of
+// [Peers] and its other messages are held back. Peers that time out are closed with
+// [ClosePolicyViolation], and peers that verify rejects with [CloseUnauthorized]. The disconnect
+// callbacks are not called for peers that never completed the handshake.
+//
+// T should not be versioned, as the client holds back versioned messages until it received the
+// [SchemaManifest] of the server, which is only sent once the handshake succeeded.
+//
+// The client sends the message from its connect callback:
+//
+//	router.OnConnect(func(sender *router.NetworkClient) {
+//		_ = sender.SendMessage(Login{Token: token})
+//	})
+func Handshake[T any](timeout time.Duration, verify func(sender *NetworkClient, message T) (identity any, err error)) {
+	msgType := reflect.TypeOf((*T)(nil)).Elem()
+	_, err := registerType(msgType)
+	if err != nil {
+		panic(err)
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+
+	handshakeMutex.Lock()
+	defer handshakeMutex.Unlock()
+
+	currentHandshake = &handshake{
+		msgType: msgType,
+		timeout: timeout,
+		verify: func(sender *NetworkClient, message any) (any, error) {
+			return verify(sender, message.(T))
+		},
+	}
+}
+
+func loadHandshake() *handshake {
+	handshakeMutex.RLock()
+	defer handshakeMutex.RUnlock()
+
+	return currentHandshake
+}
+
+// pendingHandshake is the state of a peer that has not completed the handshake.
+type pendingHandshake struct {
+	handshake *handshake
+	timer     *time.Timer
+	backlog   [][]byte
+	failed    bool
+}
+
+// beginHandshake waits for the handshake message of the client.
+func (c *NetworkClient) beginHandshake(hs *handshake) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+
+	c.pending = &pendingHandshake{handshake: hs}
+	c.pending.timer = time.AfterFunc(hs.timeout, func() {
+		c.failHandshake(ClosePolicyViolation, ErrHandshakeTimeout)
+	})
+}
+
+// receiveHandshake handles a message received before the handshake succeeded. It returns false
+// if the handshake already succeeded, and the message should be processed as usual.
+func (c *NetworkClient) receiveHandshake(msg []byte) (bool, error) {
+	c.stateMutex.Lock()
+	pending := c.pending
+	if pending == nil {
+		c.stateMutex.Unlock()
+		return false, nil
+	}
+	if pending.failed {
+		c.stateMutex.Unlock()
+		return true, nil
+	}
+
+	instance, err := mapper.Deserialize(msg)
+	if err != nil || reflect.TypeOf(instance) != pending.handshake.msgType {
+		if len(pending.backlog) >= maxHandshakeBacklog {
+			c.stateMutex.Unlock()
+			c.failHandshake(ClosePolicyViolation, ErrHandshakeRequired)
+			return true, ErrHandshakeRequired
+		}
+
+		pending.backlog = append(pending.backlog, msg)
+		c.stateMutex.Unlock()
+		return true, nil
+	}
+	c.stateMutex.Unlock()
+
+	identity, err := pending.handshake.verify(c, instance)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrHandshakeRejected, err)
+		c.failHandshake(CloseUnauthorized, err)
+		return true, err
+	}
+
+	c.stateMutex.Lock()
+	if pending.failed {
+		// The handshake timed out while it was being verified.
+		c.stateMutex.Unlock()
+		return true, nil
+	}
+	pending.timer.Stop()
+	if identity != nil {
+		c.identity = identity
+	}
+	c.pending = nil
+	c.stateMutex.Unlock()
+
+	accept(c)
+
+	for _, msg := range pending.backlog {
+		err = ProcessMessage(c, msg)
+		if err != nil {
+			ReportError(c, fmt.Errorf("unable to process message: %w", err))
+		}
+	}
+	return true, nil
+}
+
+// failHandshake closes the connection of a client that did not complete the handshake.
+func (c *NetworkClient) failHandshake(status CloseStatus, reason error) {
+	if !c.abandonHandshake() {
+		return
+	}
+
+	// Closing can wait for the peer, which should not hold up the caller.
+	conn := c.Conn()
+	dispatch(conn, func() {
+		_ = conn.Close(status, reason.Error())
+	})
+}
+
+// abandonHandshake stops waiting for the handshake, it returns false if there is no handshake
+// to wait for.
+func (c *NetworkClient) abandonHandshake() bool {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+
+	if c.pending == nil || c.pending.failed {
+		return false
+	}
+	c.pending.failed = true
+	c.pending.timer.Stop()
+	c.pending.backlog = nil
+	return true
+}
+
+// connected returns false if the client has not completed the handshake.
+func (c *NetworkClient) connected() bool {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+
+	return c.pending == nil
+}
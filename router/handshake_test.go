@@ -0,0 +1,85 @@
+package router
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Login struct {
+	Token string
+}
+
+func TestHandshake_Accepted(t *testing.T) {
+	ResetRouter()
+	Handshake(time.Second, func(sender *NetworkClient, message Login) (any, error) {
+		return "player-" + message.Token, nil
+	})
+
+	connected := make(chan *NetworkClient, 1)
+	OnConnect(func(sender *NetworkClient) {
+		connected <- sender
+	})
+	received := make(chan Input, 1)
+	On(func(sender *NetworkClient, message Input) {
+		received <- message
+	})
+
+	conn := newStalledConn()
+	defer conn.Close(CloseNormal, "")
+	CallConnect(conn)
+	client := Client(conn)
+	assert.Empty(t, Peers())
+
+	// Messages sent before the handshake are held back until it succeeded.
+	input, _ := Serialize(Input{Key: 1})
+	assert.Nil(t, CallProcessMessage(conn, input))
+	assert.Empty(t, received)
+
+	login, _ := Serialize(Login{Token: "1"})
+	assert.Nil(t, CallProcessMessage(conn, login))
+	assert.Equal(t, client, <-connected)
+	assert.Equal(t, Input{Key: 1}, <-received)
+	assert.Equal(t, "player-1", client.Identity())
+	assert.Equal(t, []*NetworkClient{client}, Peers())
+}
+
+func TestHandshake_Rejected(t *testing.T) {
+	ResetRouter()
+	errBadToken := errors.New("bad token")
+	Handshake(time.Second, func(sender *NetworkClient, message Login) (any, error) {
+		return nil, errBadToken
+	})
+
+	disconnected := make(chan struct{}, 1)
+	OnDisconnect(func(sender *NetworkClient, err error) {
+		disconnected <- struct{}{}
+	})
+
+	conn := newStalledConn()
+	CallConnect(conn)
+
+	login, _ := Serialize(Login{Token: "1"})
+	err := CallProcessMessage(conn, login)
+	assert.ErrorIs(t, err, ErrHandshakeRejected)
+	assert.ErrorIs(t, err, errBadToken)
+	assert.Eventually(t, func() bool { return conn.closeStatus() == CloseUnauthorized }, time.Second, time.Millisecond)
+
+	// The peer never connected, so it never disconnects either.
+	CallDisconnect(conn, nil)
+	assert.Empty(t, disconnected)
+}
+
+func TestHandshake_Timeout(t *testing.T) {
+	ResetRouter()
+	Handshake(time.Millisecond*10, func(sender *NetworkClient, message Login) (any, error) {
+		return nil, nil
+	})
+
+	conn := newStalledConn()
+	CallConnect(conn)
+	assert.Eventually(t, func() bool { return conn.closeStatus() == ClosePolicyViolation }, time.Second, time.Millisecond)
+	assert.Empty(t, Peers())
+}
@@ -2,32 +2,139 @@ package router
 
 import (
 	"context"
+	"errors"
 	"fmt"
-
-	"github.com/coder/websocket"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type NetworkClient struct {
 	id string
-	*websocket.Conn
-	ctx context.Context
+
+	// link is the connection the peer is reached over, it is replaced when the peer resumes
+	// its session over a new connection, see EnableSessions.
+	link atomic.Pointer[link]
+
+	// manifest is the SchemaManifest announced by the peer. Until it is received, versioned
+	// messages and everything sent after them are held back in held. They are guarded by
+	// manifestMutex.
+	manifest      SchemaManifest
+	received      bool
+	flushing      bool
+	held          []heldMessage
+	manifestMutex sync.RWMutex
+
+	// identity is set by the transport or the handshake, pending is set until the handshake
+	// succeeded and session is the token of the session of the peer. They are guarded by
+	// stateMutex.
+	identity   any
+	pending    *pendingHandshake
+	session    string
+	stateMutex sync.Mutex
+}
+
+// link is a connection of a client.
+type link struct {
+	// transport is the connection of the transport, which identifies the client in the router.
+	// Messages are written to conn, which might wrap it, see SetConnWrapper.
+	transport Conn
+	conn      Conn
+	ctx       context.Context
+	addr      net.Addr
+
+	// queue contains the messages waiting to be written, see SendMessage.
+	queue     *sendQueue
+	queueOnce sync.Once
+}
+
+func newLink(ctx context.Context, transport Conn, conn Conn) *link {
+	return &link{transport: transport, conn: conn, ctx: ctx, addr: conn.RemoteAddr()}
+}
+
+func NewNetworkClient(ctx context.Context, underlying Conn) *NetworkClient {
+	client := &NetworkClient{
+		id: GetId(underlying),
+	}
+	client.link.Store(newLink(ctx, underlying, underlying))
+	if identified, ok := underlying.(IdentifiedConn); ok {
+		client.identity = identified.Identity()
+	}
+	return client
+}
+
+// Conn returns the underlying connection of the transport.
+func (c *NetworkClient) Conn() Conn {
+	if c == nil {
+		return nil
+	}
+	if l := c.link.Load(); l != nil {
+		return l.conn
+	}
+	return nil
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *NetworkClient) RemoteAddr() net.Addr {
+	if l := c.link.Load(); l != nil {
+		return l.addr
+	}
+	return nil
+}
+
+// Identity returns the identity of the peer, as returned by the authentication of the transport
+// or by the [Handshake]. It is nil if the peer did not authenticate.
+func (c *NetworkClient) Identity() any {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+
+	return c.identity
 }
 
-func NewNetworkClient(ctx context.Context, underlying *websocket.Conn) *NetworkClient {
-	return &NetworkClient{
-		id:   GetId(underlying),
-		Conn: underlying,
-		ctx:  ctx,
+// Context returns the context of the connection, which is canceled once the peer disconnects.
+// It can be used to stop work that is done on behalf of the peer.
+func (c *NetworkClient) Context() context.Context {
+	if l := c.link.Load(); l != nil {
+		return l.ctx
 	}
+	return context.Background()
+}
+
+// Outbound returns true if the connection was opened by this process to a server in the same
+// process, see [OutboundConn].
+func (c *NetworkClient) Outbound() bool {
+	conn := c.Conn()
+	return conn != nil && outbound(conn)
 }
 
+// SendMessage queues the message to be sent to the peer. Messages are written by a goroutine of
+// the client, so SendMessage never blocks on a slow peer. Errors that occur while writing are
+// reported to the [OnError] callbacks and close the connection.
+//
+// If the send queue of the client is full, the [QueuePolicy] decides whether a message is
+// dropped or the client is disconnected, in which case [ErrQueueFull] is returned.
+//
+// Versioned messages are only serialized once the [SchemaManifest] of the peer is known, until
+// then they are held back together with everything sent after them.
 func (c *NetworkClient) SendMessage(msg any) error {
-	payload, err := Serialize(msg)
+	held, err := c.hold(heldMessage{msg: msg})
+	if held || err != nil {
+		return err
+	}
+
+	return c.sendMessage(msg)
+}
+
+func (c *NetworkClient) sendMessage(msg any) error {
+	payload, err := serializeFor(c, msg)
 	if err != nil {
 		return fmt.Errorf("unable to serialize message: %w", err)
 	}
 
-	err = c.Conn.Write(c.ctx, websocket.MessageBinary, payload)
+	msgType := reflect.TypeOf(msg)
+	err = c.enqueueNow(queuedMessage{msgType: msgType, channel: channelOf(msgType), payload: payload})
 	if err != nil {
 		return fmt.Errorf("unable to write message: %w", err)
 	}
@@ -35,15 +142,222 @@ func (c *NetworkClient) SendMessage(msg any) error {
 	return nil
 }
 
+// send queues the serialized message of the given type to be sent over the channel of the type.
+func (c *NetworkClient) send(msgType reflect.Type, payload []byte) error {
+	return c.enqueue(queuedMessage{msgType: msgType, channel: channelOf(msgType), payload: payload})
+}
+
+// SendMessageBytes queues an already serialized message to be sent over [ReliableOrdered].
 func (c *NetworkClient) SendMessageBytes(msgBytes []byte) error {
-	err := c.Conn.Write(c.ctx, websocket.MessageBinary, msgBytes)
-	if err != nil {
+	// The bytes might be reused by the caller before they are written.
+	payload := append([]byte(nil), msgBytes...)
+	return c.enqueue(queuedMessage{channel: ReliableOrdered, payload: payload})
+}
+
+func (c *NetworkClient) enqueue(message queuedMessage) error {
+	held, err := c.hold(heldMessage{message: message})
+	if held || err != nil {
 		return err
 	}
 
-	return nil
+	return c.enqueueNow(message)
+}
+
+func (c *NetworkClient) enqueueNow(message queuedMessage) error {
+	l := c.link.Load()
+	if l == nil {
+		return ErrClientDisconnected
+	}
+
+	// Synchronous connections never block, and their messages have to be written in the order
+	// of the calls, so they do not need a queue.
+	if synchronous(l.conn) {
+		return l.write(message)
+	}
+
+	queue := c.sendQueue(l)
+	err := queue.push(message)
+	if errors.Is(err, ErrQueueFull) {
+		queue.close()
+		go func() {
+			_ = l.conn.Close(CloseTryAgainLater, ErrQueueFull.Error())
+		}()
+	}
+	return err
+}
+
+// sendQueue returns the send queue of the link, and starts its writer the first time.
+func (c *NetworkClient) sendQueue(l *link) *sendQueue {
+	l.queueOnce.Do(func() {
+		l.queue = newSendQueue(currentQueueOptions())
+		go c.writeLoop(l)
+	})
+	return l.queue
+}
+
+// writeLoop writes the queued messages until the connection is closed or a write fails.
+func (c *NetworkClient) writeLoop(l *link) {
+	queue := l.queue
+	defer queue.close()
+
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-queue.wake:
+		}
+
+		for {
+			message, ok := queue.pop()
+			if !ok {
+				break
+			}
+
+			err := l.write(message)
+			if err != nil {
+				if l.ctx.Err() == nil {
+					ReportError(c, fmt.Errorf("unable to write message: %w", err))
+					_ = l.conn.Close(CloseInternalError, "unable to write message")
+				}
+				return
+			}
+			queue.written()
+		}
+	}
+}
+
+func (l *link) write(message queuedMessage) error {
+	if writer, ok := l.conn.(ChannelWriter); ok {
+		return writer.WriteChannel(l.ctx, message.channel, message.payload)
+	}
+
+	// Everything else is sent over the reliable stream, where delivery is already guaranteed.
+	return l.conn.Write(l.ctx, message.payload)
+}
+
+// QueueStats returns the metrics of the send queue of the client.
+func (c *NetworkClient) QueueStats() QueueStats {
+	l := c.link.Load()
+	if l == nil || synchronous(l.conn) {
+		return QueueStats{}
+	}
+	return c.sendQueue(l).snapshot()
+}
+
+// Disconnect closes the connection to the peer with the given reason. The messages that are
+// already queued are written first, unless that takes longer than [QueueOptions.FlushTimeout].
+func (c *NetworkClient) Disconnect(reason string) error {
+	l := c.link.Load()
+	if l == nil {
+		return nil
+	}
+
+	c.flush(l)
+	return l.conn.Close(ClosePolicyViolation, reason)
+}
+
+// flush waits until the queued messages of the link are written, the connection is closed or
+// the flush timeout passed.
+func (c *NetworkClient) flush(l *link) {
+	if synchronous(l.conn) {
+		return
+	}
+
+	queue := c.sendQueue(l)
+	timer := time.NewTimer(queue.options.FlushTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-queue.wait():
+	case <-l.ctx.Done():
+	case <-timer.C:
+	}
 }
 
 func (c *NetworkClient) Id() string {
 	return c.id
 }
+
+// Manifest returns the schema versions the peer announced for the types of the mapper added
+// under the name with [AddManifest]. It returns false until the manifest of the peer arrived,
+// values of versioned types should not be sent to the peer before.
+func (c *NetworkClient) Manifest(name string) (map[uint]uint, bool) {
+	c.manifestMutex.RLock()
+	defer c.manifestMutex.RUnlock()
+
+	return c.manifest.Manifests[name], c.received
+}
+
+// heldMessage is a message held back until the manifest of the peer is known, either a message
+// that still has to be serialized or an already serialized one.
+type heldMessage struct {
+	msg     any
+	message queuedMessage
+}
+
+func (m heldMessage) versioned() bool {
+	return m.msg != nil && versioned(m.msg)
+}
+
+// hold holds back the message if the manifest of the peer is not known yet, and the message is
+// versioned or has to stay behind a held message. It returns false if it can be sent right away.
+func (c *NetworkClient) hold(message heldMessage) (bool, error) {
+	c.manifestMutex.Lock()
+	defer c.manifestMutex.Unlock()
+
+	if c.received || (!message.versioned() && len(c.held) == 0 && !c.flushing) {
+		return false, nil
+	}
+	if len(c.held) >= currentQueueOptions().Size {
+		return true, ErrQueueFull
+	}
+
+	c.held = append(c.held, message)
+	return true, nil
+}
+
+// receiveManifest stores the manifest of the peer and sends the messages held back until now.
+func (c *NetworkClient) receiveManifest(manifest SchemaManifest) {
+	c.manifestMutex.Lock()
+	c.manifest = manifest
+	if c.received || c.flushing {
+		c.manifestMutex.Unlock()
+		return
+	}
+	c.flushing = true
+
+	for len(c.held) > 0 {
+		message := c.held[0]
+		c.held = c.held[1:]
+		c.manifestMutex.Unlock()
+
+		var err error
+		if message.msg != nil {
+			err = c.sendMessage(message.msg)
+		} else {
+			err = c.enqueueNow(message.message)
+		}
+		if err != nil {
+			ReportError(c, err)
+		}
+
+		c.manifestMutex.Lock()
+	}
+
+	c.held = nil
+	c.flushing = false
+	c.received = true
+	c.manifestMutex.Unlock()
+}
+
+// version returns the schema version the peer announced for the type id, or 0 if unknown.
+func (c *NetworkClient) version(id uint) uint {
+	if c == nil {
+		return 0
+	}
+
+	c.manifestMutex.RLock()
+	defer c.manifestMutex.RUnlock()
+
+	return c.manifest.Versions[id]
+}
@@ -8,7 +8,6 @@ import (
 	"reflect"
 	"sync"
 
-	"github.com/coder/websocket"
 	"github.com/leap-fish/necs/typeid"
 	"github.com/leap-fish/necs/typemapper"
 )
@@ -25,46 +24,79 @@ var (
 	disconnectCallbacks []func(sender *NetworkClient, err error)
 	errorCallbacks      []func(sender *NetworkClient, err error)
 
+	// disconnecting counts the disconnect callbacks that are running, see WaitDisconnects.
+	disconnecting callbackGroup
+
 	callbacks = make(map[reflect.Type][]any)
 
-	idMap          = make(map[*websocket.Conn]string)
+	// connWrapper wraps the connections of new NetworkClients, see SetConnWrapper.
+	connWrapper func(conn Conn) Conn
+
+	idMap          = make(map[Conn]string)
 	idMapMutex     sync.Mutex
-	clientMap      = make(map[*websocket.Conn]*NetworkClient)
+	clientMap      = make(map[Conn]*NetworkClient)
 	clientMapMutex sync.Mutex
 )
 
+func init() {
+	registerSchemaManifest()
+	registerSessionGranted()
+}
+
 // On adds a callback to be called whenever the specified message type T is received.
-// Note: sender will be nil in client callbacks.
-// This can return an error if the type id is reserved or already in use.
-func On[T any](callback func(sender *NetworkClient, message T)) {
+// On the client, sender is the connection to the server, see [NetworkClient.Outbound].
+// This panics if the type id of T collides with a different registered message type,
+// in which case one of them should be given an explicit name using [typeid.Named].
+//
+// Options such as [WithChannel] configure how the message type is sent.
+func On[T any](callback func(sender *NetworkClient, message T), opts ...Option) {
 	handlerType := reflect.TypeOf(callback).In(1)
 
 	// Register the type in the type registry.
-	id := typeid.GetTypeId(handlerType)
+	_, err := registerType(handlerType)
+	if err != nil {
+		panic(err)
+	}
 
-	// Error is ignored because it just means there is already a mapping with this type registered, so the mapper
-	// does not want to register another one. Not an issue for this call.
-	_ = mapper.RegisterType(id, handlerType)
+	for _, opt := range opts {
+		opt(handlerType)
+	}
 
 	// Add the callback to the router.
 	// So we can reference it when processing messages.
 	callbacks[handlerType] = append(callbacks[handlerType], callback)
 }
 
+// registerType registers the message type in the type registry if it is not already registered,
+// and returns its id.
+func registerType(msgType reflect.Type) (uint, error) {
+	if id := mapper.LookupId(msgType); id != 0 {
+		return id, nil
+	}
+
+	id := typeid.GetTypeId(msgType)
+	err := mapper.RegisterType(id, msgType)
+	if err != nil {
+		return 0, fmt.Errorf("unable to register message type %s: %w", typeid.TypeName(msgType), err)
+	}
+
+	return id, nil
+}
+
 // OnConnect adds a callback to call whenever a session connects to the server.
-// Note: sender will be nil in client callbacks.
+// On the client, sender is the connection to the server, see [NetworkClient.Outbound].
 func OnConnect(callback func(sender *NetworkClient)) {
 	connectCallbacks = append(connectCallbacks, callback)
 }
 
 // OnDisconnect adds a callback to call whenever a session disconnects from the server.
-// Note: sender will be nil in client callbacks.
+// On the client, sender is the connection to the server, see [NetworkClient.Outbound].
 func OnDisconnect(callback func(sender *NetworkClient, err error)) {
 	disconnectCallbacks = append(disconnectCallbacks, callback)
 }
 
 // OnError adds a callback to call whenever a message error occurs.
-// Note: sender will be nil in client callbacks.
+// On the client, sender is the connection to the server, see [NetworkClient.Outbound].
 func OnError(callback func(sender *NetworkClient, err error)) {
 	errorCallbacks = append(errorCallbacks, callback)
 }
@@ -95,7 +127,7 @@ func ProcessMessage(sender *NetworkClient, msg []byte) error {
 	return nil
 }
 
-func Client(conn *websocket.Conn) *NetworkClient {
+func Client(conn Conn) *NetworkClient {
 	clientMapMutex.Lock()
 	defer clientMapMutex.Unlock()
 
@@ -103,11 +135,38 @@ func Client(conn *websocket.Conn) *NetworkClient {
 	if ok {
 		return client
 	}
-	clientMap[conn] = NewNetworkClient(context.Background(), conn)
-	return clientMap[conn]
+
+	client = NewNetworkClient(conn.Context(), conn)
+	if connWrapper != nil {
+		client.link.Store(newLink(conn.Context(), conn, connWrapper(conn)))
+	}
+	clientMap[conn] = client
+	return client
+}
+
+// LookupClient returns the NetworkClient of the connection, unlike [Client] it is not created
+// if the connection has none, such as after it disconnected.
+func LookupClient(conn Conn) (*NetworkClient, bool) {
+	clientMapMutex.Lock()
+	defer clientMapMutex.Unlock()
+
+	client, ok := clientMap[conn]
+	return client, ok
 }
 
-func GetId(conn *websocket.Conn) string {
+// SetConnWrapper sets a function that wraps the connection of every new NetworkClient, so all
+// messages sent to the peer are written through the returned connection. The router keeps
+// identifying the client by the connection of the transport. Passing nil removes the wrapper.
+//
+// This is used to intercept outgoing messages, for example to simulate network conditions.
+func SetConnWrapper(wrap func(conn Conn) Conn) {
+	clientMapMutex.Lock()
+	defer clientMapMutex.Unlock()
+
+	connWrapper = wrap
+}
+
+func GetId(conn Conn) string {
 	idMapMutex.Lock()
 	defer idMapMutex.Unlock()
 
@@ -132,7 +191,10 @@ func Peers() []*NetworkClient {
 	clientMapMutex.Lock()
 	defer clientMapMutex.Unlock()
 
-	for _, v := range clientMap {
+	for conn, v := range clientMap {
+		if outbound(conn) || !v.connected() {
+			continue
+		}
 		peers = append(peers, v)
 	}
 
@@ -145,65 +207,214 @@ func Broadcast(msg any) error {
 		return err
 	}
 
+	// A client whose queue is full does not keep the message from the other clients.
+	var errs []error
+	msgType := reflect.TypeOf(msg)
 	for _, client := range Peers() {
-		err := client.SendMessageBytes(payload)
+		if versioned(msg) {
+			err = client.SendMessage(msg)
+		} else {
+			err = client.send(msgType, payload)
+		}
 		if err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 func Serialize(msg any) ([]byte, error) {
-	msgType := reflect.TypeOf(msg)
-	if mapper.LookupId(msgType) == 0 {
-		id := typeid.GetTypeId(msgType)
-		_ = mapper.RegisterType(id, msgType)
+	_, err := registerType(reflect.TypeOf(msg))
+	if err != nil {
+		return nil, err
 	}
 	return mapper.Serialize(msg)
 }
 
-func CallProcessMessage(sender *websocket.Conn, msg []byte) error {
-	return ProcessMessage(Client(sender), msg)
+func CallProcessMessage(sender Conn, msg []byte) error {
+	client := Client(sender)
+
+	handled, err := client.receiveHandshake(msg)
+	if handled {
+		return err
+	}
+
+	return ProcessMessage(client, msg)
 }
 
-func CallConnect(sender *websocket.Conn) {
+// CallConnect calls the connect callbacks for the connection, or waits for its handshake first
+// if one is required, see [Handshake].
+func CallConnect(sender Conn) {
+	if resumeSession(sender) {
+		return
+	}
+
 	client := Client(sender)
+
+	if hs := loadHandshake(); hs != nil && !outbound(sender) {
+		client.beginHandshake(hs)
+		return
+	}
+
+	accept(client)
+}
+
+// accept calls the connect callbacks of the client.
+func accept(client *NetworkClient) {
+	// Let the peer know which schema versions we understand, so it can down-convert what it sends.
+	// The manifest is sent even if it is empty, as versioned messages are held back until it
+	// arrived, see NetworkClient.SendMessage.
+	_ = client.SendMessage(buildManifest())
+
+	grantSession(client, client.link.Load().transport)
+
 	for _, callback := range connectCallbacks {
-		go callback(client)
+		dispatch(client.Conn(), func() { callback(client) })
 	}
 }
 
-func CallDisconnect(sender *websocket.Conn, err error) {
-	client := Client(sender)
-	for _, callback := range disconnectCallbacks {
-		go callback(client, err)
+// CallDisconnect calls the disconnect callbacks for the connection, unless the session of the
+// peer is kept to be resumed, see [EnableSessions].
+func CallDisconnect(sender Conn, err error) {
+	clientMapMutex.Lock()
+	client, ok := clientMap[sender]
+	clientMapMutex.Unlock()
+	if !ok {
+		return
+	}
+
+	if !client.connected() {
+		// Peers that never completed the handshake were never connected.
+		client.abandonHandshake()
+	} else if releaseSession(client, sender, func() { disconnect(client, sender, err) }) == sessionEnded {
+		disconnect(client, sender, err)
 	}
 
 	clientMapMutex.Lock()
-	defer clientMapMutex.Unlock()
+	if clientMap[sender] == client {
+		delete(clientMap, sender)
+	}
+	clientMapMutex.Unlock()
 
+	idMapMutex.Lock()
 	delete(idMap, sender)
-	delete(clientMap, sender)
+	idMapMutex.Unlock()
 }
 
-func CallError(sender *websocket.Conn, err error) {
-	client := Client(sender)
+// disconnect calls the disconnect callbacks of the client.
+func disconnect(client *NetworkClient, sender Conn, err error) {
+	for _, callback := range disconnectCallbacks {
+		disconnecting.add()
+		dispatch(sender, func() {
+			defer disconnecting.done()
+			callback(client, err)
+		})
+	}
+}
+
+// WaitDisconnects waits until the disconnect callbacks that have been called are done, or until
+// the context is done. Transports use it to shut down gracefully. The callbacks of peers whose
+// session is kept to be resumed are only called once it expires or is ended, see [EndSessions].
+func WaitDisconnects(ctx context.Context) error {
+	select {
+	case <-disconnecting.wait():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func CallError(sender Conn, err error) {
+	ReportError(Client(sender), err)
+}
+
+// ReportError calls the registered error callbacks with the given error.
+// This can be used by code built on top of the router to surface errors that did not
+// originate from the transport itself.
+func ReportError(sender *NetworkClient, err error) {
 	for _, callback := range errorCallbacks {
-		go callback(client, err)
+		dispatch(sender.Conn(), func() { callback(sender, err) })
 	}
 }
 
+// callbackGroup counts running callbacks. Unlike a sync.WaitGroup, callbacks can be added
+// while it is waited on.
+type callbackGroup struct {
+	mutex   sync.Mutex
+	running int
+	idle    chan struct{}
+}
+
+func (g *callbackGroup) add() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.running++
+}
+
+func (g *callbackGroup) done() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.running--
+	if g.running == 0 && g.idle != nil {
+		close(g.idle)
+		g.idle = nil
+	}
+}
+
+// wait returns a channel that is closed once no callbacks are running.
+func (g *callbackGroup) wait() <-chan struct{} {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	idle := make(chan struct{})
+	if g.running == 0 {
+		close(idle)
+		return idle
+	}
+	if g.idle == nil {
+		g.idle = make(chan struct{})
+	}
+	return g.idle
+}
+
+// dispatch calls the callback in a new goroutine, unless the connection is synchronous.
+func dispatch(conn Conn, callback func()) {
+	if conn != nil && synchronous(conn) {
+		callback()
+		return
+	}
+
+	go callback()
+}
+
 func ResetRouter() {
 	mapper = typemapper.NewMapper(map[uint]any{})
 	connectCallbacks = []func(sender *NetworkClient){}
 	disconnectCallbacks = []func(sender *NetworkClient, err error){}
 	errorCallbacks = []func(sender *NetworkClient, err error){}
 	callbacks = make(map[reflect.Type][]any)
+	registerSchemaManifest()
+	registerSessionGranted()
+	resetSessions()
+
+	channelsMutex.Lock()
+	channels = make(map[reflect.Type]Channel)
+	channelsMutex.Unlock()
 
 	clientMapMutex.Lock()
 	defer clientMapMutex.Unlock()
 
-	idMap = make(map[*websocket.Conn]string)
-	clientMap = make(map[*websocket.Conn]*NetworkClient)
+	idMap = make(map[Conn]string)
+	clientMap = make(map[Conn]*NetworkClient)
+	connWrapper = nil
+
+	queueOptionsMutex.Lock()
+	queueOptions = QueueOptions{}
+	queueOptionsMutex.Unlock()
+
+	handshakeMutex.Lock()
+	currentHandshake = nil
+	handshakeMutex.Unlock()
 }
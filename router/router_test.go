@@ -1,8 +1,17 @@
 package router_test
 
 import (
-	"github.com/leap-fish/necs/router"
+	"context"
+	"net"
+	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/leap-fish/necs/router"
+	"github.com/leap-fish/necs/transports"
+	"github.com/leap-fish/necs/typeid"
+	"github.com/leap-fish/necs/typemapper"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -32,6 +41,24 @@ func Test_RouterOn(t *testing.T) {
 	assert.True(t, called)
 }
 
+type FirstMessage struct{}
+type SecondMessage struct{}
+
+func Test_RouterOnCollision(t *testing.T) {
+	router.ResetRouter()
+
+	typeid.RegisterName(reflect.TypeOf(FirstMessage{}), "shared.Message")
+	typeid.RegisterName(reflect.TypeOf(SecondMessage{}), "shared.Message")
+
+	router.On[FirstMessage](func(sender *router.NetworkClient, message FirstMessage) {})
+	assert.Panics(t, func() {
+		router.On[SecondMessage](func(sender *router.NetworkClient, message SecondMessage) {})
+	})
+
+	_, err := router.Serialize(SecondMessage{})
+	assert.ErrorIs(t, err, typemapper.ErrIdCollision)
+}
+
 func BenchmarkRouter_ProcessMessage(b *testing.B) {
 	router.ResetRouter()
 	cm := ExampleChatMessage{message: "Goldroger - Perwoll"}
@@ -45,3 +72,122 @@ func BenchmarkRouter_ProcessMessage(b *testing.B) {
 		_ = router.ProcessMessage(&router.NetworkClient{}, serialized)
 	}
 }
+
+type Ping struct {
+	Count int
+}
+
+func Test_RouterLoopback(t *testing.T) {
+	router.ResetRouter()
+
+	var connects, disconnects []bool
+	router.OnConnect(func(sender *router.NetworkClient) {
+		connects = append(connects, sender.Outbound())
+	})
+	router.OnDisconnect(func(sender *router.NetworkClient, err error) {
+		disconnects = append(disconnects, sender.Outbound())
+	})
+
+	// Both ends share the router, so the server answers pings from clients and clients
+	// answer with the next count until it reaches 3.
+	var received []int
+	router.On[Ping](func(sender *router.NetworkClient, message Ping) {
+		received = append(received, message.Count)
+		if message.Count < 3 {
+			assert.Nil(t, sender.SendMessage(Ping{Count: message.Count + 1}))
+		}
+	})
+
+	loopback := transports.NewLoopbackTransport()
+	client := loopback.Connect()
+	assert.Equal(t, []bool{false, true}, connects)
+
+	// Both ends announce their schema manifest.
+	assert.Equal(t, 2, loopback.Pump())
+
+	// Only the server end is a peer.
+	assert.Len(t, router.Peers(), 1)
+
+	assert.Nil(t, client.Client().SendMessage(Ping{Count: 0}))
+	assert.Empty(t, received)
+
+	assert.Equal(t, 4, loopback.Pump())
+	assert.Equal(t, []int{0, 1, 2, 3}, received)
+
+	assert.Nil(t, router.Broadcast(Ping{Count: 3}))
+	assert.Equal(t, 1, loopback.Pump())
+
+	assert.Nil(t, client.Client().Disconnect("bye"))
+	assert.ErrorIs(t, client.Client().SendMessage(Ping{}), transports.ErrLoopbackClosed)
+	loopback.Pump()
+	assert.ElementsMatch(t, []bool{false, true}, disconnects)
+	assert.Empty(t, router.Peers())
+}
+
+func Test_RouterConnContext(t *testing.T) {
+	router.ResetRouter()
+
+	var server *router.NetworkClient
+	router.OnConnect(func(sender *router.NetworkClient) {
+		if !sender.Outbound() {
+			server = sender
+		}
+	})
+
+	loopback := transports.NewLoopbackTransport()
+	client := loopback.Connect().Client()
+	assert.NotNil(t, server)
+
+	assert.Equal(t, "client", server.RemoteAddr().String())
+	assert.Equal(t, "server", client.RemoteAddr().String())
+	assert.Nil(t, server.Context().Err())
+
+	assert.Nil(t, client.Disconnect("bye"))
+	assert.ErrorIs(t, client.Context().Err(), context.Canceled)
+	assert.ErrorIs(t, server.Context().Err(), context.Canceled)
+}
+
+// asyncConn is a connection whose callbacks are called on their own goroutines, like those of
+// a network transport.
+type asyncConn struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newAsyncConn() *asyncConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &asyncConn{ctx: ctx, cancel: cancel}
+}
+
+func (c *asyncConn) Write(ctx context.Context, payload []byte) error { return nil }
+func (c *asyncConn) Close(status router.CloseStatus, reason string) error {
+	c.cancel()
+	return nil
+}
+func (c *asyncConn) RemoteAddr() net.Addr     { return nil }
+func (c *asyncConn) Context() context.Context { return c.ctx }
+
+func Test_RouterWaitDisconnects(t *testing.T) {
+	router.ResetRouter()
+
+	release := make(chan struct{})
+	var returned atomic.Bool
+	router.OnDisconnect(func(sender *router.NetworkClient, err error) {
+		<-release
+		returned.Store(true)
+	})
+
+	conn := newAsyncConn()
+	router.CallConnect(conn)
+	_ = conn.Close(router.CloseNormal, "")
+	router.CallDisconnect(conn, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, router.WaitDisconnects(ctx), context.DeadlineExceeded)
+
+	// The disconnect callback runs on its own goroutine, it is waited for until it returns.
+	time.AfterFunc(20*time.Millisecond, func() { close(release) })
+	assert.Nil(t, router.WaitDisconnects(context.Background()))
+	assert.True(t, returned.Load())
+}
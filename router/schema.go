@@ -0,0 +1,104 @@
+package router
+
+import (
+	"reflect"
+	"sync"
+)
+
+// SchemaManifest is exchanged when a connection is established, it tells the peer which
+// schema versions of the versioned message types this side understands.
+type SchemaManifest struct {
+	Versions map[uint]uint
+	// Manifests contains the versions of the types of other mappers, such as the components
+	// of esync, keyed by the name they were added under with [AddManifest].
+	Manifests map[string]map[uint]uint
+}
+
+var (
+	manifests      = make(map[string]func() map[uint]uint)
+	manifestsMutex sync.RWMutex
+)
+
+// AddManifest adds the schema versions of another type mapper to the [SchemaManifest] sent to
+// peers, see [NetworkClient.Manifest]. It is kept by ResetRouter, like the types of the mapper.
+func AddManifest(name string, versions func() map[uint]uint) {
+	manifestsMutex.Lock()
+	defer manifestsMutex.Unlock()
+
+	manifests[name] = versions
+}
+
+// buildManifest returns the manifest of this side of the connection.
+func buildManifest() SchemaManifest {
+	manifestsMutex.RLock()
+	defer manifestsMutex.RUnlock()
+
+	manifest := SchemaManifest{Versions: mapper.Manifest()}
+	for name, versions := range manifests {
+		if manifest.Manifests == nil {
+			manifest.Manifests = make(map[string]map[uint]uint)
+		}
+		manifest.Manifests[name] = versions()
+	}
+	return manifest
+}
+
+func registerSchemaManifest() {
+	On[SchemaManifest](func(sender *NetworkClient, message SchemaManifest) {
+		if sender == nil {
+			return
+		}
+
+		sender.receiveManifest(message)
+	})
+}
+
+// RegisterMigration registers an older schema version of the message type T.
+// Messages received from peers that still use the Old shape are upgraded to T using up, and
+// messages sent to such peers are down-converted using down.
+//
+// T has to implement [typemapper.Versioned] and Old should describe the wire format of
+// the given version, for example:
+//
+//	type ChatMessage struct {
+//		Author  string
+//		Message string
+//	}
+//
+//	func (ChatMessage) SchemaVersion() uint { return 2 }
+//
+//	type ChatMessageV1 struct {
+//		Message string
+//	}
+//
+//	router.RegisterMigration(1,
+//		func(old ChatMessageV1) ChatMessage { return ChatMessage{Message: old.Message} },
+//		func(msg ChatMessage) ChatMessageV1 { return ChatMessageV1{Message: msg.Message} },
+//	)
+func RegisterMigration[T any, Old any](version uint, up func(Old) T, down func(T) Old) error {
+	id, err := registerType(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return err
+	}
+
+	return mapper.RegisterLegacy(id, version, reflect.TypeOf((*Old)(nil)).Elem(),
+		func(value any) any { return up(value.(Old)) },
+		func(value any) any { return down(value.(T)) },
+	)
+}
+
+// serializeFor serializes the message using the schema version the receiving client understands.
+func serializeFor(client *NetworkClient, msg any) ([]byte, error) {
+	id, err := registerType(reflect.TypeOf(msg))
+	if err != nil {
+		return nil, err
+	}
+
+	return mapper.SerializeVersion(msg, client.version(id))
+}
+
+// versioned returns true if the message type has a schema version and might need to be
+// serialized differently for each peer.
+func versioned(msg any) bool {
+	return mapper.Version(mapper.LookupId(reflect.TypeOf(msg))) > 0
+}
@@ -0,0 +1,56 @@
+package router
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Chat struct {
+	Author  string
+	Message string
+}
+
+func (Chat) SchemaVersion() uint { return 2 }
+
+type ChatV1 struct {
+	Message string
+}
+
+func TestSchemaManifest_HoldsVersionedMessages(t *testing.T) {
+	ResetRouter()
+	assert.Nil(t, RegisterMigration(1,
+		func(old ChatV1) Chat { return Chat{Message: old.Message} },
+		func(msg Chat) ChatV1 { return ChatV1{Message: msg.Message} },
+	))
+
+	conn := newStalledConn()
+	close(conn.release)
+	defer conn.Close(CloseNormal, "")
+	client := Client(conn)
+
+	// Nothing is sent until the peer announced which version it understands, and the input
+	// stays behind the chat message.
+	assert.Nil(t, client.SendMessage(Chat{Author: "a", Message: "hi"}))
+	assert.Nil(t, client.SendMessage(Input{Key: 1}))
+	_, ok := client.Manifest("esync")
+	assert.False(t, ok)
+	assert.Zero(t, conn.writtenCount())
+
+	id := mapper.LookupId(reflect.TypeOf(Chat{}))
+	client.receiveManifest(SchemaManifest{Versions: map[uint]uint{id: 1}})
+	_, ok = client.Manifest("esync")
+	assert.True(t, ok)
+	assert.Eventually(t, func() bool { return conn.writtenCount() == 2 }, time.Second, time.Millisecond)
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	chat, err := mapper.Deserialize(conn.written[0])
+	assert.Nil(t, err)
+	assert.Equal(t, Chat{Message: "hi"}, chat)
+	input, err := mapper.Deserialize(conn.written[1])
+	assert.Nil(t, err)
+	assert.Equal(t, Input{Key: 1}, input)
+}
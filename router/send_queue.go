@@ -0,0 +1,258 @@
+package router
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultQueueSize    = 256
+	DefaultFlushTimeout = time.Second * 5
+)
+
+var (
+	ErrQueueFull          = errors.New("send queue is full")
+	ErrClientDisconnected = errors.New("client is disconnected")
+)
+
+// QueuePolicy decides what happens when a message is sent to a client whose send queue is full.
+// Messages sent over reliable channels are never dropped, so a client whose queue is full of
+// reliable messages is always disconnected.
+type QueuePolicy uint8
+
+const (
+	// Coalesce drops a queued message sent over [UnreliableSequenced] when a newer message of the
+	// same type is queued, so a slow client only receives the newest world snapshot. If the queue is
+	// still full, the oldest unreliable message is dropped. This is the default.
+	Coalesce QueuePolicy = iota
+	// DropOldest queues every message, and drops the oldest unreliable message when the queue
+	// is full.
+	DropOldest
+	// DisconnectOnFull queues every message, and disconnects the client when the queue is full.
+	DisconnectOnFull
+)
+
+func (p QueuePolicy) String() string {
+	switch p {
+	case Coalesce:
+		return "coalesce"
+	case DropOldest:
+		return "drop-oldest"
+	case DisconnectOnFull:
+		return "disconnect-on-full"
+	}
+	return "unknown"
+}
+
+// QueueOptions configures the send queues of clients.
+type QueueOptions struct {
+	// Size is the number of messages that can be queued for a client, defaults to [DefaultQueueSize].
+	Size   int
+	Policy QueuePolicy
+	// FlushTimeout is how long [NetworkClient.Disconnect] waits for the queued messages to be
+	// written, defaults to [DefaultFlushTimeout].
+	FlushTimeout time.Duration
+}
+
+// QueueStats are the metrics of the send queue of a client.
+type QueueStats struct {
+	// Depth is the number of messages waiting to be written.
+	Depth int
+	// Sent is the number of messages written to the connection.
+	Sent uint64
+	// Dropped is the number of unreliable messages dropped because the queue was full.
+	Dropped uint64
+	// Coalesced is the number of messages replaced by a newer message of the same type.
+	Coalesced uint64
+}
+
+var (
+	queueOptions      QueueOptions
+	queueOptionsMutex sync.RWMutex
+)
+
+// SetQueueOptions configures the send queues of clients that connect afterwards.
+func SetQueueOptions(options QueueOptions) {
+	queueOptionsMutex.Lock()
+	defer queueOptionsMutex.Unlock()
+
+	queueOptions = options
+}
+
+func currentQueueOptions() QueueOptions {
+	queueOptionsMutex.RLock()
+	defer queueOptionsMutex.RUnlock()
+
+	options := queueOptions
+	if options.Size <= 0 {
+		options.Size = DefaultQueueSize
+	}
+	if options.FlushTimeout <= 0 {
+		options.FlushTimeout = DefaultFlushTimeout
+	}
+	return options
+}
+
+type queuedMessage struct {
+	msgType reflect.Type
+	channel Channel
+	payload []byte
+}
+
+func (m queuedMessage) reliable() bool {
+	return m.channel == ReliableOrdered || m.channel == ReliableUnordered
+}
+
+// sendQueue is the bounded queue of messages waiting to be written to a client.
+type sendQueue struct {
+	options QueueOptions
+
+	mutex    sync.Mutex
+	messages []queuedMessage
+	closed   bool
+	stats    QueueStats
+	// writing is set while a popped message is being written, idle is closed once the queue
+	// is empty and nothing is being written.
+	writing bool
+	idle    chan struct{}
+
+	// wake is signaled when a message is queued.
+	wake chan struct{}
+}
+
+func newSendQueue(options QueueOptions) *sendQueue {
+	return &sendQueue{
+		options: options,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// push queues the message according to the policy of the queue. It returns [ErrQueueFull] if
+// the client has to be disconnected.
+func (q *sendQueue) push(message queuedMessage) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return ErrClientDisconnected
+	}
+
+	// The replaced message is removed and the new one is queued at the end, so it is not
+	// written before the messages queued in between.
+	coalesced := false
+	if q.options.Policy == Coalesce && message.channel == UnreliableSequenced {
+		for i := range q.messages {
+			if q.messages[i].msgType == message.msgType && q.messages[i].channel == UnreliableSequenced {
+				q.remove(i)
+				q.stats.Coalesced++
+				coalesced = true
+				break
+			}
+		}
+	}
+
+	if !coalesced && len(q.messages) >= q.options.Size {
+		if q.options.Policy == DisconnectOnFull || !q.dropOldest() {
+			return ErrQueueFull
+		}
+	}
+
+	q.messages = append(q.messages, message)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// dropOldest removes the oldest unreliable message, the mutex must be held.
+func (q *sendQueue) dropOldest() bool {
+	for i, message := range q.messages {
+		if message.reliable() {
+			continue
+		}
+
+		q.remove(i)
+		q.stats.Dropped++
+		return true
+	}
+	return false
+}
+
+// remove removes the message at the index, the mutex must be held.
+func (q *sendQueue) remove(i int) {
+	copy(q.messages[i:], q.messages[i+1:])
+	q.messages[len(q.messages)-1] = queuedMessage{}
+	q.messages = q.messages[:len(q.messages)-1]
+}
+
+// pop removes the oldest message to be written, it returns false if the queue is empty.
+func (q *sendQueue) pop() (queuedMessage, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.messages) == 0 {
+		q.writing = false
+		q.notifyIdle()
+		return queuedMessage{}, false
+	}
+
+	message := q.messages[0]
+	q.messages[0] = queuedMessage{}
+	q.messages = q.messages[1:]
+	q.writing = true
+	return message, true
+}
+
+// wait returns a channel that is closed once the queued messages are written, or the queue
+// is closed.
+func (q *sendQueue) wait() <-chan struct{} {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.idle == nil {
+		q.idle = make(chan struct{})
+	}
+	idle := q.idle
+	if q.closed || (len(q.messages) == 0 && !q.writing) {
+		q.notifyIdle()
+	}
+	return idle
+}
+
+// notifyIdle wakes up the waiters of the queue, the mutex must be held.
+func (q *sendQueue) notifyIdle() {
+	if q.idle != nil {
+		close(q.idle)
+		q.idle = nil
+	}
+}
+
+func (q *sendQueue) written() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.stats.Sent++
+}
+
+// close discards the queued messages, later pushes fail.
+func (q *sendQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.closed = true
+	q.messages = nil
+	q.notifyIdle()
+}
+
+func (q *sendQueue) snapshot() QueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	stats := q.stats
+	stats.Depth = len(q.messages)
+	return stats
+}
@@ -0,0 +1,185 @@
+package router
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Input struct {
+	Key int
+}
+
+// stalledConn is a connection whose writes block until it is released, like a slow peer.
+type stalledConn struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	release chan struct{}
+
+	mutex   sync.Mutex
+	written [][]byte
+	status  CloseStatus
+}
+
+func newStalledConn() *stalledConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &stalledConn{ctx: ctx, cancel: cancel, release: make(chan struct{})}
+}
+
+func (c *stalledConn) Write(ctx context.Context, payload []byte) error {
+	select {
+	case <-c.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.written = append(c.written, payload)
+	return nil
+}
+
+func (c *stalledConn) Close(status CloseStatus, reason string) error {
+	c.mutex.Lock()
+	c.status = status
+	c.mutex.Unlock()
+
+	c.cancel()
+	return nil
+}
+
+func (c *stalledConn) RemoteAddr() net.Addr     { return nil }
+func (c *stalledConn) Context() context.Context { return c.ctx }
+func (c *stalledConn) closeStatus() CloseStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.status
+}
+
+func (c *stalledConn) writtenCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.written)
+}
+func TestSendQueue_CoalescesSnapshots(t *testing.T) {
+	ResetRouter()
+	SetChannel[Snapshot](UnreliableSequenced)
+	SetQueueOptions(QueueOptions{Size: 4})
+
+	conn := newStalledConn()
+	client := Client(conn)
+	defer conn.Close(CloseNormal, "")
+
+	// Sending never blocks on the stalled connection.
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, client.SendMessage(Snapshot{Tick: i}))
+	}
+	assert.Nil(t, client.SendMessage(Input{Key: 1}))
+
+	stats := client.QueueStats()
+	assert.LessOrEqual(t, stats.Depth, 3)
+	assert.GreaterOrEqual(t, stats.Coalesced, uint64(97))
+
+	close(conn.release)
+	assert.Eventually(t, func() bool { return client.QueueStats().Depth == 0 }, time.Second, time.Millisecond)
+
+	// Only the newest snapshot is written after the one that was in progress.
+	assert.LessOrEqual(t, conn.writtenCount(), 3)
+	assert.Equal(t, uint64(conn.writtenCount()), client.QueueStats().Sent)
+}
+
+func TestSendQueue_DropOldest(t *testing.T) {
+	ResetRouter()
+	SetChannel[Snapshot](Unreliable)
+	SetQueueOptions(QueueOptions{Size: 2, Policy: DropOldest})
+
+	conn := newStalledConn()
+	client := Client(conn)
+	defer conn.Close(CloseNormal, "")
+
+	assert.Nil(t, client.SendMessage(Input{Key: 1}))
+	assert.Eventually(t, func() bool { return client.QueueStats().Depth == 0 }, time.Second, time.Millisecond)
+
+	// The first input is being written, the reliable message is kept over the snapshots.
+	assert.Nil(t, client.SendMessage(Input{Key: 2}))
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, client.SendMessage(Snapshot{Tick: i}))
+	}
+	stats := client.QueueStats()
+	assert.Equal(t, 2, stats.Depth)
+	assert.Equal(t, uint64(4), stats.Dropped)
+
+	// Once the queue is full of reliable messages the client has to be disconnected.
+	assert.Nil(t, client.SendMessage(Input{Key: 3}))
+	assert.ErrorIs(t, client.SendMessage(Input{Key: 4}), ErrQueueFull)
+	assert.Eventually(t, func() bool { return conn.closeStatus() == CloseTryAgainLater }, time.Second, time.Millisecond)
+}
+
+func TestSendQueue_DisconnectOnFull(t *testing.T) {
+	ResetRouter()
+	SetQueueOptions(QueueOptions{Size: 1, Policy: DisconnectOnFull})
+
+	conn := newStalledConn()
+	client := Client(conn)
+
+	assert.Nil(t, client.SendMessage(Input{Key: 1}))
+	assert.Eventually(t, func() bool { return client.QueueStats().Depth == 0 }, time.Second, time.Millisecond)
+	assert.Nil(t, client.SendMessage(Input{Key: 2}))
+	assert.ErrorIs(t, client.SendMessage(Input{Key: 3}), ErrQueueFull)
+	assert.Eventually(t, func() bool { return conn.closeStatus() == CloseTryAgainLater }, time.Second, time.Millisecond)
+
+	assert.ErrorIs(t, client.SendMessage(Input{Key: 4}), ErrClientDisconnected)
+}
+
+func TestSendQueue_CoalesceKeepsOrder(t *testing.T) {
+	queue := newSendQueue(QueueOptions{Size: 4})
+	snapshot := queuedMessage{msgType: reflect.TypeOf(Snapshot{}), channel: UnreliableSequenced, payload: []byte{1}}
+	input := queuedMessage{msgType: reflect.TypeOf(Input{}), channel: ReliableOrdered}
+	newer := queuedMessage{msgType: reflect.TypeOf(Snapshot{}), channel: UnreliableSequenced, payload: []byte{2}}
+
+	assert.Nil(t, queue.push(snapshot))
+	assert.Nil(t, queue.push(input))
+	assert.Nil(t, queue.push(newer))
+
+	// The newer snapshot is not written before the input that was sent before it.
+	first, _ := queue.pop()
+	second, _ := queue.pop()
+	assert.Equal(t, input, first)
+	assert.Equal(t, newer, second)
+	_, ok := queue.pop()
+	assert.False(t, ok)
+}
+
+func TestNetworkClient_DisconnectFlushesQueue(t *testing.T) {
+	ResetRouter()
+
+	conn := newStalledConn()
+	client := Client(conn)
+
+	assert.Nil(t, client.SendMessage(Input{Key: 1}))
+	time.AfterFunc(20*time.Millisecond, func() { close(conn.release) })
+
+	// The message sent before disconnecting is written before the connection is closed.
+	assert.Nil(t, client.Disconnect("bye"))
+	assert.Equal(t, 1, conn.writtenCount())
+	assert.Equal(t, ClosePolicyViolation, conn.closeStatus())
+}
+
+func TestNetworkClient_DisconnectFlushTimeout(t *testing.T) {
+	ResetRouter()
+	SetQueueOptions(QueueOptions{FlushTimeout: 20 * time.Millisecond})
+
+	conn := newStalledConn()
+	client := Client(conn)
+
+	assert.Nil(t, client.SendMessage(Input{Key: 1}))
+	assert.Nil(t, client.Disconnect("bye"))
+	assert.Equal(t, 0, conn.writtenCount())
+	assert.Equal(t, ClosePolicyViolation, conn.closeStatus())
+}
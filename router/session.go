@@ -0,0 +1,268 @@
+package router
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const DefaultSessionGrace = time.Second * 30
+
+// SessionGranted is sent to peers that connect to a server with sessions enabled, it contains
+// the token the peer presents to resume its session after reconnecting, see [EnableSessions].
+type SessionGranted struct {
+	Token string
+}
+
+// ResumableConn can be implemented by the connections of transports that let peers present the
+// token of their session when connecting, such as in a header of the WebSocket upgrade.
+type ResumableConn interface {
+	Conn
+	SessionToken() string
+}
+
+// session is the session of a peer, it outlives the connection of the peer by the grace period.
+type session struct {
+	client *NetworkClient
+	// conn is the connection of the transport the peer is connected over.
+	conn Conn
+
+	suspended bool
+	timer     *time.Timer
+	// expire calls the disconnect callbacks of the suspended session.
+	expire func()
+}
+
+var (
+	sessionGrace    time.Duration
+	sessions        = make(map[string]*session)
+	sessionsMutex   sync.Mutex
+	resumeCallbacks []func(sender *NetworkClient)
+)
+
+// EnableSessions gives every peer that connects a session, which is kept for the grace period
+// after the peer disconnects. A peer that reconnects within the grace period and presents the
+// token of its session is given back its NetworkClient, including its identity, instead of
+// connecting as a new peer. The disconnect callbacks are only called once the grace period has
+// passed, and the [OnResume] callbacks are called when the peer is back.
+//
+// Tokens are secrets, anyone who presents a token resumes its session without a [Handshake].
+// Peers are sent their token in a [SessionGranted] message, see [NetworkClient.SessionToken].
+// Resuming requires a transport whose connections implement [ResumableConn].
+func EnableSessions(grace time.Duration) {
+	if grace <= 0 {
+		grace = DefaultSessionGrace
+	}
+
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	sessionGrace = grace
+}
+
+// OnResume adds a callback to call whenever a peer resumes its session over a new connection.
+// Everything sent to the peer while it was disconnected is lost, so it should be sent the full
+// state it needs.
+func OnResume(callback func(sender *NetworkClient)) {
+	resumeCallbacks = append(resumeCallbacks, callback)
+}
+
+func registerSessionGranted() {
+	On[SessionGranted](func(sender *NetworkClient, message SessionGranted) {
+		if sender == nil {
+			return
+		}
+
+		sender.stateMutex.Lock()
+		defer sender.stateMutex.Unlock()
+
+		// The session of a client is never replaced by its peer.
+		if sender.session == "" {
+			sender.session = message.Token
+		}
+	})
+}
+
+// SessionToken returns the token of the session. On the server it is the token the client was
+// granted, and on the client it is the token received from the server, which the transport
+// presents when reconnecting. It is empty if sessions are not enabled.
+func (c *NetworkClient) SessionToken() string {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+
+	return c.session
+}
+
+// grantSession gives the newly connected client a session, if sessions are enabled.
+func grantSession(client *NetworkClient, conn Conn) {
+	if outbound(conn) {
+		return
+	}
+
+	sessionsMutex.Lock()
+	if sessionGrace == 0 {
+		sessionsMutex.Unlock()
+		return
+	}
+
+	bytes := make([]byte, 16)
+	_, _ = rand.Read(bytes)
+	token := fmt.Sprintf("%x", bytes)
+	sessions[token] = &session{client: client, conn: conn}
+	sessionsMutex.Unlock()
+
+	client.stateMutex.Lock()
+	client.session = token
+	client.stateMutex.Unlock()
+
+	_ = client.SendMessage(SessionGranted{Token: token})
+}
+
+// resumeSession gives the connection the NetworkClient of the session it presents. It returns
+// false if the connection does not resume a session.
+func resumeSession(conn Conn) bool {
+	resumable, ok := conn.(ResumableConn)
+	if !ok {
+		return false
+	}
+	token := resumable.SessionToken()
+	if token == "" {
+		return false
+	}
+
+	sessionsMutex.Lock()
+	s, ok := sessions[token]
+	if !ok {
+		sessionsMutex.Unlock()
+		return false
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	previous, suspended := s.conn, s.suspended
+	s.conn, s.suspended, s.timer = conn, false, nil
+	sessionsMutex.Unlock()
+
+	client := s.client
+
+	clientMapMutex.Lock()
+	if !suspended {
+		// The peer reconnected before its previous connection was found to be dead.
+		delete(clientMap, previous)
+	}
+	clientMap[conn] = client
+	wrapped := conn
+	if connWrapper != nil {
+		wrapped = connWrapper(conn)
+	}
+	clientMapMutex.Unlock()
+
+	idMapMutex.Lock()
+	delete(idMap, previous)
+	idMap[conn] = client.id
+	idMapMutex.Unlock()
+
+	client.link.Store(newLink(conn.Context(), conn, wrapped))
+
+	// The peer is reconnected with a new client of its own, which does not know the schema
+	// manifest or the token yet. Its versioned messages are held back until the manifest arrived.
+	_ = client.SendMessage(buildManifest())
+	_ = client.SendMessage(SessionGranted{Token: token})
+
+	if !suspended {
+		dispatch(previous, func() {
+			_ = previous.Close(CloseNormal, "session resumed")
+		})
+	}
+
+	for _, callback := range resumeCallbacks {
+		dispatch(conn, func() { callback(client) })
+	}
+	return true
+}
+
+// sessionRelease is what became of the session of a client whose connection closed.
+type sessionRelease uint8
+
+const (
+	// sessionEnded means the client has no session, so it is disconnected.
+	sessionEnded sessionRelease = iota
+	// sessionSuspended means the session is kept for the grace period.
+	sessionSuspended
+	// sessionMoved means the peer already resumed its session over another connection.
+	sessionMoved
+)
+
+// releaseSession decides what becomes of the session of the client when the connection closes.
+// A session that is still on the connection is kept for the grace period, after which expire
+// is called. The decision is made under a single lock, so a peer that resumes its session at
+// the same time is never disconnected.
+func releaseSession(client *NetworkClient, conn Conn, expire func()) sessionRelease {
+	token := client.SessionToken()
+	if token == "" {
+		return sessionEnded
+	}
+
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	s, ok := sessions[token]
+	switch {
+	case !ok || s.client != client:
+		return sessionEnded
+	case s.conn != conn:
+		return sessionMoved
+	}
+
+	s.suspended = true
+	s.expire = expire
+	s.timer = time.AfterFunc(sessionGrace, func() {
+		sessionsMutex.Lock()
+		if sessions[token] != s || !s.suspended || s.conn != conn {
+			// The session was resumed in the meantime.
+			sessionsMutex.Unlock()
+			return
+		}
+		delete(sessions, token)
+		sessionsMutex.Unlock()
+
+		expire()
+	})
+	return sessionSuspended
+}
+
+// EndSessions ends every session right away, without waiting for the grace period. The
+// disconnect callbacks of peers whose session is suspended are called now, and the peers that are
+// still connected are disconnected as soon as their connection closes. Transports call it when
+// shutting down, so [WaitDisconnects] also waits for the peers that could have resumed.
+func EndSessions() {
+	sessionsMutex.Lock()
+	var expired []func()
+	for token, s := range sessions {
+		if s.suspended {
+			s.timer.Stop()
+			expired = append(expired, s.expire)
+		}
+		delete(sessions, token)
+	}
+	sessionsMutex.Unlock()
+
+	for _, expire := range expired {
+		expire()
+	}
+}
+
+func resetSessions() {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	for _, s := range sessions {
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+	}
+	sessions = make(map[string]*session)
+	sessionGrace = 0
+	resumeCallbacks = []func(sender *NetworkClient){}
+}
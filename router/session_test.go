@@ -0,0 +1,238 @@
+package router_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/leap-fish/necs/router"
+	"github.com/leap-fish/necs/transports"
+	"github.com/stretchr/testify/assert"
+)
+
+// sessionRecorder records the server ends that connect, resume and disconnect.
+type sessionRecorder struct {
+	mutex                          sync.Mutex
+	connects, resumes, disconnects []*router.NetworkClient
+}
+
+func recordSessions() *sessionRecorder {
+	recorder := &sessionRecorder{}
+	record := func(clients *[]*router.NetworkClient) func(sender *router.NetworkClient) {
+		return func(sender *router.NetworkClient) {
+			if sender.Outbound() {
+				return
+			}
+			recorder.mutex.Lock()
+			defer recorder.mutex.Unlock()
+			*clients = append(*clients, sender)
+		}
+	}
+
+	router.OnConnect(record(&recorder.connects))
+	router.OnResume(record(&recorder.resumes))
+	disconnect := record(&recorder.disconnects)
+	router.OnDisconnect(func(sender *router.NetworkClient, err error) {
+		disconnect(sender)
+	})
+	return recorder
+}
+
+func (r *sessionRecorder) counts() (int, int, int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.connects), len(r.resumes), len(r.disconnects)
+}
+
+func Test_SessionResume(t *testing.T) {
+	router.ResetRouter()
+	router.EnableSessions(time.Minute)
+	recorder := recordSessions()
+
+	loopback := transports.NewLoopbackTransport()
+	client := loopback.Connect()
+	loopback.Pump()
+
+	server := recorder.connects[0]
+	token := client.Client().SessionToken()
+	assert.NotEmpty(t, token)
+	assert.Equal(t, server.SessionToken(), token)
+
+	// The server keeps the session of the client that lost its connection.
+	assert.Nil(t, client.Client().Disconnect("connection lost"))
+	loopback.Pump()
+	_, _, disconnects := recorder.counts()
+	assert.Zero(t, disconnects)
+
+	client = loopback.Reconnect(token)
+	loopback.Pump()
+	assert.Equal(t, []*router.NetworkClient{server}, recorder.resumes)
+	assert.Len(t, recorder.connects, 1)
+	assert.Equal(t, []*router.NetworkClient{server}, router.Peers())
+
+	// The new client is told the token of the session it resumed.
+	assert.Equal(t, token, client.Client().SessionToken())
+	assert.Nil(t, server.SendMessage(Ping{Count: 1}))
+	assert.Equal(t, 1, loopback.Pump())
+}
+
+// Greeting is versioned, so it is held back until the manifest of the peer arrived.
+type Greeting struct {
+	Count int
+}
+
+func (Greeting) SchemaVersion() uint { return 1 }
+
+func Test_SessionResumeVersioned(t *testing.T) {
+	router.ResetRouter()
+	router.EnableSessions(time.Minute)
+
+	var received []int
+	router.On[Greeting](func(sender *router.NetworkClient, message Greeting) {
+		received = append(received, message.Count)
+		if !sender.Outbound() {
+			assert.Nil(t, sender.SendMessage(Greeting{Count: message.Count + 1}))
+		}
+	})
+
+	loopback := transports.NewLoopbackTransport()
+	client := loopback.Connect()
+	loopback.Pump()
+	token := client.Client().SessionToken()
+	assert.Nil(t, client.Client().Disconnect("connection lost"))
+	loopback.Pump()
+
+	// The new client is sent the manifest again, so its versioned messages are not held forever.
+	client = loopback.Reconnect(token)
+	defer client.Close(router.CloseNormal, "")
+	loopback.Pump()
+	assert.Nil(t, client.Client().SendMessage(Greeting{Count: 1}))
+	loopback.Pump()
+	loopback.Pump()
+	assert.Equal(t, []int{1, 2}, received)
+}
+
+func Test_SessionExpire(t *testing.T) {
+	router.ResetRouter()
+	router.EnableSessions(time.Millisecond * 10)
+	recorder := recordSessions()
+
+	loopback := transports.NewLoopbackTransport()
+	client := loopback.Connect()
+	loopback.Pump()
+	token := client.Client().SessionToken()
+
+	assert.Nil(t, client.Client().Disconnect("connection lost"))
+	loopback.Pump()
+	assert.Eventually(t, func() bool {
+		_, _, disconnects := recorder.counts()
+		return disconnects == 1
+	}, time.Second, time.Millisecond)
+
+	// An expired token connects a new peer.
+	loopback.Reconnect(token)
+	loopback.Pump()
+	connects, resumes, _ := recorder.counts()
+	assert.Equal(t, 2, connects)
+	assert.Zero(t, resumes)
+	assert.NotEqual(t, token, recorder.connects[1].SessionToken())
+}
+
+func Test_SessionResumeRacesDisconnect(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		router.ResetRouter()
+		router.EnableSessions(time.Minute)
+		recorder := recordSessions()
+
+		loopback := transports.NewLoopbackTransport()
+		client := loopback.Connect()
+		loopback.Pump()
+		server := recorder.connects[0]
+		previous := server.Conn()
+		token := client.Client().SessionToken()
+
+		// The previous connection is found to be dead while the peer resumes its session, either
+		// way the peer stays connected.
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			router.CallDisconnect(previous, nil)
+		}()
+		loopback.Reconnect(token)
+		wg.Wait()
+		loopback.Pump()
+
+		_, resumes, disconnects := recorder.counts()
+		assert.Equal(t, 1, resumes)
+		assert.Zero(t, disconnects)
+		assert.Equal(t, []*router.NetworkClient{server}, router.Peers())
+	}
+}
+
+func Test_SessionEnd(t *testing.T) {
+	router.ResetRouter()
+	router.EnableSessions(time.Minute)
+	recorder := recordSessions()
+
+	loopback := transports.NewLoopbackTransport()
+	suspended := loopback.Connect()
+	connected := loopback.Connect()
+	loopback.Pump()
+
+	assert.Nil(t, suspended.Client().Disconnect("connection lost"))
+	loopback.Pump()
+
+	// Ended sessions are disconnected without waiting for the grace period.
+	router.EndSessions()
+	_, _, disconnects := recorder.counts()
+	assert.Equal(t, 1, disconnects)
+
+	assert.Nil(t, connected.Client().Disconnect("connection lost"))
+	loopback.Pump()
+	_, _, disconnects = recorder.counts()
+	assert.Equal(t, 2, disconnects)
+	assert.Empty(t, router.Peers())
+}
+
+func Test_SessionShutdown(t *testing.T) {
+	router.ResetRouter()
+	router.EnableSessions(time.Minute)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := listener.Addr().String()
+
+	// The clients live in the same process, only the server ends are counted.
+	var serverEnds sync.Map
+	var connects, disconnects atomic.Int32
+	router.OnConnect(func(sender *router.NetworkClient) {
+		if sender.Conn().RemoteAddr().String() != addr {
+			serverEnds.Store(sender, true)
+			connects.Add(1)
+		}
+	})
+	router.OnDisconnect(func(sender *router.NetworkClient, err error) {
+		if _, ok := serverEnds.Load(sender); ok {
+			disconnects.Add(1)
+		}
+	})
+
+	server := transports.NewWsServerTransport(0, "", nil)
+	go func() { _ = server.StartListener(context.Background(), listener) }()
+
+	for i := 0; i < 2; i++ {
+		client := transports.NewWsClientTransport("ws://" + addr)
+		go func() { _ = client.Start(nil) }()
+	}
+	assert.Eventually(t, func() bool { return connects.Load() == 2 }, time.Second*5, time.Millisecond)
+
+	// The sessions of the peers are not kept once the server is gone.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	assert.Nil(t, server.Shutdown(ctx))
+	assert.Equal(t, int32(2), disconnects.Load())
+}
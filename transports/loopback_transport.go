@@ -0,0 +1,211 @@
+package transports
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/leap-fish/necs/router"
+)
+
+var ErrLoopbackClosed = errors.New("loopback connection is closed")
+
+// LoopbackTransport connects a server and any number of clients running in the same process
+// through memory, for tests and single-player games. Both ends of a connection use the same
+// router and esync code paths as a network transport would.
+//
+// Nothing is delivered until [LoopbackTransport.Pump] is called, which delivers all pending
+// messages on the calling goroutine in a deterministic order. The connect, disconnect and
+// error callbacks of loopback connections are called synchronously.
+//
+// As the router is shared by the server and the clients, the connect and disconnect callbacks
+// are called for both ends of a connection. The client end is an outbound connection, use
+// [router.NetworkClient.Outbound] to tell them apart. It is left out of [router.Peers], so the
+// server never sends to it.
+type LoopbackTransport struct {
+	mutex sync.Mutex
+	// ends contains both ends of every connection in the order they were connected,
+	// which is the order they are pumped in.
+	ends []*LoopbackConn
+}
+
+func NewLoopbackTransport() *LoopbackTransport {
+	return &LoopbackTransport{}
+}
+
+// loopbackEvent is a message or the disconnect of the connection, delivered to an end.
+type loopbackEvent struct {
+	payload    []byte
+	disconnect bool
+}
+
+// LoopbackConn is one end of a loopback connection.
+type LoopbackConn struct {
+	transport *LoopbackTransport
+	peer      *LoopbackConn
+	client    bool
+	session   string
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	// Guarded by the mutex of the transport.
+	pending []loopbackEvent
+	closed  bool
+}
+
+// Connect connects a new client to the server and returns the client end of the connection.
+// The connect callbacks are called for the server end first, then for the client end.
+func (l *LoopbackTransport) Connect() *LoopbackConn {
+	return l.Reconnect("")
+}
+
+// Reconnect connects a new client that presents the session token to the server, like a client
+// reconnecting after losing its connection, see [router.EnableSessions].
+func (l *LoopbackTransport) Reconnect(token string) *LoopbackConn {
+	server := newLoopbackConn(l, false)
+	server.session = token
+	client := newLoopbackConn(l, true)
+	server.peer = client
+	client.peer = server
+
+	l.mutex.Lock()
+	l.ends = append(l.ends, server, client)
+	l.mutex.Unlock()
+
+	router.CallConnect(server)
+	router.CallConnect(client)
+
+	return client
+}
+
+func newLoopbackConn(transport *LoopbackTransport, client bool) *LoopbackConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &LoopbackConn{transport: transport, client: client, ctx: ctx, cancel: cancel}
+}
+
+// Pump delivers the pending messages of all connections until there are none left, including
+// messages sent by the callbacks while pumping. It returns the number of delivered messages.
+// Callbacks that always answer each other are never done pumping.
+func (l *LoopbackTransport) Pump() int {
+	delivered := 0
+	for {
+		ends, events := l.take()
+		if len(ends) == 0 {
+			return delivered
+		}
+
+		for i, end := range ends {
+			for _, event := range events[i] {
+				if event.disconnect {
+					router.CallDisconnect(end, nil)
+					continue
+				}
+
+				delivered++
+				err := router.CallProcessMessage(end, event.payload)
+				if err != nil {
+					router.CallError(end, fmt.Errorf("unable to process message: %w", err))
+				}
+			}
+		}
+	}
+}
+
+// take removes the pending events of all ends, in connection order.
+func (l *LoopbackTransport) take() ([]*LoopbackConn, [][]loopbackEvent) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var ends []*LoopbackConn
+	var events [][]loopbackEvent
+	remaining := l.ends[:0]
+	for _, end := range l.ends {
+		if len(end.pending) > 0 {
+			ends = append(ends, end)
+			events = append(events, end.pending)
+			end.pending = nil
+		}
+
+		// Ends are forgotten once their disconnect has been taken.
+		if !end.closed || len(end.pending) > 0 {
+			remaining = append(remaining, end)
+		}
+	}
+	clear(l.ends[len(remaining):])
+	l.ends = remaining
+
+	return ends, events
+}
+
+// Write queues the payload for the other end of the connection.
+func (c *LoopbackConn) Write(ctx context.Context, payload []byte) error {
+	c.transport.mutex.Lock()
+	defer c.transport.mutex.Unlock()
+
+	if c.closed {
+		return ErrLoopbackClosed
+	}
+
+	// The payload might be reused by the caller after writing.
+	c.peer.pending = append(c.peer.pending, loopbackEvent{payload: append([]byte(nil), payload...)})
+	return nil
+}
+
+// Close closes the connection. Messages that have already been written are delivered first,
+// then the disconnect callbacks are called for both ends on the next pump.
+func (c *LoopbackConn) Close(status router.CloseStatus, reason string) error {
+	c.transport.mutex.Lock()
+	defer c.transport.mutex.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	for _, end := range []*LoopbackConn{c, c.peer} {
+		end.closed = true
+		end.cancel()
+		end.pending = append(end.pending, loopbackEvent{disconnect: true})
+	}
+	return nil
+}
+
+// RemoteAddr returns the address of the other end of the connection.
+func (c *LoopbackConn) RemoteAddr() net.Addr {
+	if c.client {
+		return loopbackAddr("server")
+	}
+	return loopbackAddr("client")
+}
+
+// Context returns a context that is canceled once the connection is closed.
+func (c *LoopbackConn) Context() context.Context {
+	return c.ctx
+}
+
+// SessionToken returns the session token presented by the client, for the server end of the
+// connection.
+func (c *LoopbackConn) SessionToken() string {
+	return c.session
+}
+
+// Outbound returns true for the client end of the connection.
+func (c *LoopbackConn) Outbound() bool {
+	return c.client
+}
+
+func (c *LoopbackConn) Synchronous() bool {
+	return true
+}
+
+// Client returns the NetworkClient of this end of the connection.
+func (c *LoopbackConn) Client() *router.NetworkClient {
+	return router.Client(c)
+}
+
+// loopbackAddr is the address of an end of a loopback connection.
+type loopbackAddr string
+
+func (a loopbackAddr) Network() string { return "loopback" }
+func (a loopbackAddr) String() string  { return string(a) }
@@ -1,5 +1,8 @@
 package transports
 
+import "context"
+
 type NetworkTransport interface {
-	Start() error
+	// Start runs the transport until it fails or the context is done.
+	Start(ctx context.Context) error
 }
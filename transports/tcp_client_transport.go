@@ -0,0 +1,24 @@
+package transports
+
+import (
+	"github.com/leap-fish/necs/wraptcp"
+)
+
+type TcpClientTransport struct {
+	dialAddress string
+
+	client *wraptcp.TCPClient
+}
+
+func NewTcpClientTransport(dialAddress string, options *wraptcp.Options) *TcpClientTransport {
+	return &TcpClientTransport{
+		dialAddress: dialAddress,
+
+		client: wraptcp.NewTCPClient(tcpEventHandler{}, options),
+	}
+}
+
+// Start connects to the server and blocks until the connection is closed.
+func (n *TcpClientTransport) Start(callback func(conn *wraptcp.Conn)) error {
+	return n.client.Dial(n.dialAddress, callback)
+}
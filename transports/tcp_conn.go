@@ -0,0 +1,37 @@
+package transports
+
+import (
+	"context"
+	"net"
+
+	"github.com/leap-fish/necs/router"
+	"github.com/leap-fish/necs/wraptcp"
+)
+
+// tcpConn is the router connection of a TCP connection.
+type tcpConn struct {
+	conn *wraptcp.Conn
+}
+
+// TCPConn returns the router connection of the TCP connection, which can be passed to
+// [router.Client] to get its NetworkClient.
+func TCPConn(conn *wraptcp.Conn) router.Conn {
+	return tcpConn{conn: conn}
+}
+
+func (c tcpConn) Write(ctx context.Context, payload []byte) error {
+	return c.conn.Write(ctx, payload)
+}
+
+// Close closes the connection, TCP has no way of telling the peer the status or reason.
+func (c tcpConn) Close(status router.CloseStatus, reason string) error {
+	return c.conn.Close()
+}
+
+func (c tcpConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c tcpConn) Context() context.Context {
+	return c.conn.Context()
+}
@@ -0,0 +1,65 @@
+package transports
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/leap-fish/necs/router"
+	"github.com/leap-fish/necs/wraptcp"
+)
+
+type TcpServerTransport struct {
+	Port    uint
+	Address string
+
+	server *wraptcp.TCPServer
+}
+
+func NewTcpServerTransport(port uint, address string, options *wraptcp.Options) *TcpServerTransport {
+	return &TcpServerTransport{
+		Port:    port,
+		Address: address,
+		server:  wraptcp.NewTCPServer(tcpEventHandler{}, options),
+	}
+}
+
+// Start serves connections until the context is done, then the server is closed.
+func (n *TcpServerTransport) Start(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		_ = n.Close()
+	})
+	defer stop()
+
+	err := n.server.Serve(fmt.Sprintf("%s:%d", n.Address, n.Port))
+	if err != nil {
+		return fmt.Errorf("could not start server transport: %w", err)
+	}
+
+	return nil
+}
+
+// Close stops accepting new connections.
+func (n *TcpServerTransport) Close() error {
+	return n.server.Close()
+}
+
+type tcpEventHandler struct{}
+
+func (t tcpEventHandler) OnConnect(ctx context.Context, conn *wraptcp.Conn) {
+	router.CallConnect(TCPConn(conn))
+}
+
+func (t tcpEventHandler) OnDisconnect(ctx context.Context, conn *wraptcp.Conn, err error) {
+	router.CallDisconnect(TCPConn(conn), err)
+}
+
+func (t tcpEventHandler) OnError(ctx context.Context, conn *wraptcp.Conn, err error) {
+	router.CallError(TCPConn(conn), err)
+}
+
+func (t tcpEventHandler) OnMessage(ctx context.Context, conn *wraptcp.Conn, payload []byte) {
+	err := router.CallProcessMessage(TCPConn(conn), payload)
+	if err != nil {
+		router.CallError(TCPConn(conn), fmt.Errorf("unable to process message: %w", err))
+	}
+}
@@ -0,0 +1,24 @@
+package transports
+
+import (
+	"github.com/leap-fish/necs/wrapudp"
+)
+
+type UdpClientTransport struct {
+	dialAddress string
+
+	client *wrapudp.UDPClient
+}
+
+func NewUdpClientTransport(dialAddress string, options *wrapudp.Options) *UdpClientTransport {
+	return &UdpClientTransport{
+		dialAddress: dialAddress,
+
+		client: wrapudp.NewUDPClient(udpEventHandler{}, options),
+	}
+}
+
+// Start connects to the server and blocks until the connection is closed.
+func (n *UdpClientTransport) Start(callback func(conn *wrapudp.Conn)) error {
+	return n.client.Dial(n.dialAddress, callback)
+}
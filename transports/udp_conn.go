@@ -0,0 +1,54 @@
+package transports
+
+import (
+	"context"
+	"net"
+
+	"github.com/leap-fish/necs/router"
+	"github.com/leap-fish/necs/wrapudp"
+)
+
+// udpConn is the router connection of a UDP connection, it sends messages over the channels
+// of the router natively.
+type udpConn struct {
+	conn *wrapudp.Conn
+}
+
+// UDPConn returns the router connection of the UDP connection, which can be passed to
+// [router.Client] to get its NetworkClient.
+func UDPConn(conn *wrapudp.Conn) router.Conn {
+	return udpConn{conn: conn}
+}
+
+func (c udpConn) Write(ctx context.Context, payload []byte) error {
+	return c.conn.Write(ctx, payload)
+}
+
+func (c udpConn) WriteChannel(ctx context.Context, channel router.Channel, payload []byte) error {
+	return c.conn.WriteChannel(ctx, udpChannel(channel), payload)
+}
+
+// Close closes the connection, UDP has no way of telling the peer the status or reason.
+func (c udpConn) Close(status router.CloseStatus, reason string) error {
+	return c.conn.Close()
+}
+
+func (c udpConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c udpConn) Context() context.Context {
+	return c.conn.Context()
+}
+
+func udpChannel(channel router.Channel) wrapudp.Channel {
+	switch channel {
+	case router.ReliableUnordered:
+		return wrapudp.ReliableUnordered
+	case router.Unreliable:
+		return wrapudp.Unreliable
+	case router.UnreliableSequenced:
+		return wrapudp.UnreliableSequenced
+	}
+	return wrapudp.ReliableOrdered
+}
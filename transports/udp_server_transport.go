@@ -0,0 +1,65 @@
+package transports
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/leap-fish/necs/router"
+	"github.com/leap-fish/necs/wrapudp"
+)
+
+type UdpServerTransport struct {
+	Port    uint
+	Address string
+
+	server *wrapudp.UDPServer
+}
+
+func NewUdpServerTransport(port uint, address string, options *wrapudp.Options) *UdpServerTransport {
+	return &UdpServerTransport{
+		Port:    port,
+		Address: address,
+		server:  wrapudp.NewUDPServer(udpEventHandler{}, options),
+	}
+}
+
+// Start serves connections until the context is done, then the server is closed.
+func (n *UdpServerTransport) Start(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		_ = n.Close()
+	})
+	defer stop()
+
+	err := n.server.Serve(fmt.Sprintf("%s:%d", n.Address, n.Port))
+	if err != nil {
+		return fmt.Errorf("could not start server transport: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes all connections and stops the server.
+func (n *UdpServerTransport) Close() error {
+	return n.server.Close()
+}
+
+type udpEventHandler struct{}
+
+func (t udpEventHandler) OnConnect(ctx context.Context, conn *wrapudp.Conn) {
+	router.CallConnect(UDPConn(conn))
+}
+
+func (t udpEventHandler) OnDisconnect(ctx context.Context, conn *wrapudp.Conn, err error) {
+	router.CallDisconnect(UDPConn(conn), err)
+}
+
+func (t udpEventHandler) OnError(ctx context.Context, conn *wrapudp.Conn, err error) {
+	router.CallError(UDPConn(conn), err)
+}
+
+func (t udpEventHandler) OnMessage(ctx context.Context, conn *wrapudp.Conn, payload []byte) {
+	err := router.CallProcessMessage(UDPConn(conn), payload)
+	if err != nil {
+		router.CallError(UDPConn(conn), fmt.Errorf("unable to process message: %w", err))
+	}
+}
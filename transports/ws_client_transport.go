@@ -2,6 +2,8 @@ package transports
 
 import (
 	"context"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/coder/websocket"
@@ -14,17 +16,33 @@ type WsClientTransport struct {
 	dialAddress string
 
 	client *wrapws.WebSocketClient
+
+	reconnect *wrapws.Backoff
+	// session is the token of the session granted by the server over the previous connection.
+	session      string
+	sessionMutex sync.Mutex
 }
 
 func NewWsClientTransport(dialAddress string) *WsClientTransport {
-	return &WsClientTransport{
+	transport := &WsClientTransport{
 		dialAddress: dialAddress,
-
-		client: wrapws.NewWebSocketClient(wsClientEventHandler{}),
 	}
+	transport.client = wrapws.NewWebSocketClient(&wsClientEventHandler{transport: transport})
+	return transport
+}
+
+// SetReconnect makes Start reconnect with the backoff whenever the connection is lost. When the
+// server has sessions enabled, the client presents the token of its session when reconnecting,
+// and is given back its state on the server, see [router.EnableSessions].
+func (n *WsClientTransport) SetReconnect(backoff wrapws.Backoff) {
+	n.reconnect = &backoff
 }
 
 func (n *WsClientTransport) Start(callback func(conn *websocket.Conn)) error {
+	if n.reconnect != nil {
+		return n.client.DialReconnect(context.Background(), n.dialAddress, n.dialOptions, *n.reconnect, callback)
+	}
+
 	errs, _ := errgroup.WithContext(context.Background())
 	errs.Go(func() error {
 		err := n.client.Dial(n.dialAddress, nil, callback)
@@ -34,26 +52,49 @@ func (n *WsClientTransport) Start(callback func(conn *websocket.Conn)) error {
 	return errs.Wait()
 }
 
+// dialOptions presents the token of the session when reconnecting.
+func (n *WsClientTransport) dialOptions() *websocket.DialOptions {
+	n.sessionMutex.Lock()
+	defer n.sessionMutex.Unlock()
+
+	if n.session == "" {
+		return nil
+	}
+	return &websocket.DialOptions{
+		HTTPHeader: http.Header{wrapws.SessionHeader: []string{n.session}},
+	}
+}
+
 type wsClientEventHandler struct {
-	deadline time.Duration
+	transport *WsClientTransport
+	deadline  time.Duration
 }
 
-func (w wsClientEventHandler) OnConnect(ctx context.Context, conn *websocket.Conn) {
-	router.CallConnect(conn)
+func (w *wsClientEventHandler) OnConnect(ctx context.Context, conn *websocket.Conn) {
+	openWebSocket(ctx, conn)
+	router.CallConnect(WebSocketConn(conn))
 }
 
-func (w wsClientEventHandler) OnDisconnect(ctx context.Context, conn *websocket.Conn, err error) {
-	router.CallDisconnect(conn, err)
+func (w *wsClientEventHandler) OnDisconnect(ctx context.Context, conn *websocket.Conn, err error) {
+	// The token is lost with the client once it is disconnected.
+	if token := router.Client(WebSocketConn(conn)).SessionToken(); token != "" {
+		w.transport.sessionMutex.Lock()
+		w.transport.session = token
+		w.transport.sessionMutex.Unlock()
+	}
+
+	closeWebSocket(conn)
+	router.CallDisconnect(WebSocketConn(conn), err)
 }
 
-func (w wsClientEventHandler) OnError(ctx context.Context, conn *websocket.Conn, err error) {
-	router.CallError(conn, err)
+func (w *wsClientEventHandler) OnError(ctx context.Context, conn *websocket.Conn, err error) {
+	router.CallError(WebSocketConn(conn), err)
 }
 
-func (w wsClientEventHandler) OnMessage(ctx context.Context, conn *websocket.Conn, payload []byte) {
-	err := router.CallProcessMessage(conn, payload)
+func (w *wsClientEventHandler) OnMessage(ctx context.Context, conn *websocket.Conn, payload []byte) {
+	err := router.CallProcessMessage(WebSocketConn(conn), payload)
 	if err != nil {
-		router.CallError(conn, err)
+		router.CallError(WebSocketConn(conn), err)
 	}
 }
 
@@ -0,0 +1,111 @@
+package transports
+
+import (
+	"context"
+	"net"
+
+	"github.com/coder/websocket"
+	"github.com/leap-fish/necs/internal/syncx"
+	"github.com/leap-fish/necs/router"
+	"github.com/leap-fish/necs/wrapws"
+)
+
+// webSockets contains the state of the open WebSockets, which the WebSocket itself has no
+// room for.
+var webSockets syncx.Map[*websocket.Conn, *wsState]
+
+type wsState struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	addr     net.Addr
+	identity any
+	session  string
+}
+
+// closedContext is the context of WebSockets that are not open.
+var closedContext = func() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}()
+
+// wsConn is the router connection of a WebSocket, it is a comparable value so wrapping the
+// same WebSocket twice results in the same router connection.
+type wsConn struct {
+	conn *websocket.Conn
+}
+
+// WebSocketConn returns the router connection of the WebSocket, which can be passed to
+// [router.Client] to get its NetworkClient.
+func WebSocketConn(conn *websocket.Conn) router.Conn {
+	return wsConn{conn: conn}
+}
+
+// openWebSocket keeps the state of a WebSocket until it is closed, the context is the one
+// passed to the event handler.
+func openWebSocket(ctx context.Context, conn *websocket.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	webSockets.Store(conn, &wsState{
+		ctx:      ctx,
+		cancel:   cancel,
+		addr:     wrapws.RemoteAddr(ctx),
+		identity: wrapws.Identity(ctx),
+		session:  wrapws.SessionToken(ctx),
+	})
+}
+
+// closeWebSocket cancels the context of the WebSocket and forgets its state.
+func closeWebSocket(conn *websocket.Conn) {
+	state, ok := webSockets.LoadAndDelete(conn)
+	if ok {
+		state.cancel()
+	}
+}
+
+func (c wsConn) Write(ctx context.Context, payload []byte) error {
+	return c.conn.Write(ctx, websocket.MessageBinary, payload)
+}
+
+func (c wsConn) Close(status router.CloseStatus, reason string) error {
+	if state, ok := webSockets.Load(c.conn); ok {
+		state.cancel()
+	}
+	return c.conn.Close(websocket.StatusCode(status), reason)
+}
+
+// RemoteAddr returns the address of the peer, or nil once the WebSocket has disconnected.
+func (c wsConn) RemoteAddr() net.Addr {
+	state, ok := webSockets.Load(c.conn)
+	if !ok {
+		return nil
+	}
+	return state.addr
+}
+
+func (c wsConn) Context() context.Context {
+	state, ok := webSockets.Load(c.conn)
+	if !ok {
+		return closedContext
+	}
+	return state.ctx
+}
+
+// Identity returns the identity returned by the authenticator of the server, see
+// [WsServerTransport.SetAuthenticator].
+func (c wsConn) Identity() any {
+	state, ok := webSockets.Load(c.conn)
+	if !ok {
+		return nil
+	}
+	return state.identity
+}
+
+// SessionToken returns the session token the client presented when connecting, see
+// [router.EnableSessions].
+func (c wsConn) SessionToken() string {
+	state, ok := webSockets.Load(c.conn)
+	if !ok {
+		return ""
+	}
+	return state.session
+}
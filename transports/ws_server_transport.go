@@ -3,6 +3,8 @@ package transports
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"time"
 
 	"github.com/coder/websocket"
@@ -10,6 +12,8 @@ import (
 	"github.com/leap-fish/necs/wrapws"
 )
 
+const DefaultShutdownTimeout = time.Second * 10
+
 type WsServerTransport struct {
 	Port    uint
 	Address string
@@ -25,8 +29,34 @@ func NewWsServerTransport(port uint, Address string, options *websocket.AcceptOp
 	}
 }
 
-func (n *WsServerTransport) Start() error {
-	err := n.server.Serve(fmt.Sprintf("%s:%d", n.Address, n.Port))
+// Start serves connections on the address and port until the context is done, then it shuts
+// down gracefully within [DefaultShutdownTimeout], see [WsServerTransport.Shutdown].
+func (n *WsServerTransport) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", n.Address, n.Port))
+	if err != nil {
+		return fmt.Errorf("could not start server transport: %w", err)
+	}
+
+	return n.StartListener(ctx, listener)
+}
+
+// StartListener serves connections on the listener until the context is done, like
+// [WsServerTransport.Start]. This is used to serve on Unix sockets, or over TLS by setting
+// the TLSConfig of the [WsServerTransport.HTTPServer].
+func (n *WsServerTransport) StartListener(ctx context.Context, listener net.Listener) error {
+	shutdown := make(chan error, 1)
+	stop := context.AfterFunc(ctx, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+		defer cancel()
+
+		shutdown <- n.Shutdown(ctx)
+	})
+
+	err := n.server.ServeListener(listener)
+	if !stop() {
+		// The context is done, wait until the connections are drained.
+		return <-shutdown
+	}
 	if err != nil {
 		return fmt.Errorf("could not start server transport: %w", err)
 	}
@@ -34,25 +64,66 @@ func (n *WsServerTransport) Start() error {
 	return nil
 }
 
+// Handler returns the handler that accepts WebSocket connections, so the transport can be
+// mounted on any path of an existing server instead of being started:
+//
+//	mux.Handle("/game", transport.Handler())
+//
+// Use [WsServerTransport.Shutdown] to close the connections before stopping that server.
+func (n *WsServerTransport) Handler() http.Handler {
+	return n.server
+}
+
+// HTTPServer returns the server used by [WsServerTransport.Start], which can be configured
+// before starting, such as its TLSConfig or timeouts.
+func (n *WsServerTransport) HTTPServer() *http.Server {
+	return n.server.HTTPServer()
+}
+
+// SetAuthenticator authenticates the upgrade requests of connections, the identity it returns
+// becomes the identity of the NetworkClient. It has to be set before starting. Use
+// [router.Handshake] to authenticate peers with a message after they connected instead.
+func (n *WsServerTransport) SetAuthenticator(auth wrapws.Authenticator) {
+	n.server.SetAuthenticator(auth)
+}
+
+// Shutdown stops accepting connections and closes every connection with a going away close
+// frame. It waits until the disconnect callbacks of all peers have returned, or until the
+// context is done, after which the remaining connections are closed right away. The sessions of
+// peers are ended, so they are disconnected without waiting for the grace period, see
+// [router.EndSessions].
+func (n *WsServerTransport) Shutdown(ctx context.Context) error {
+	router.EndSessions()
+
+	err := n.server.Shutdown(ctx)
+	if err != nil {
+		return err
+	}
+
+	return router.WaitDisconnects(ctx)
+}
+
 type wsEventHandler struct {
 	deadline time.Duration
 }
 
 func (w wsEventHandler) OnConnect(ctx context.Context, conn *websocket.Conn) {
-	router.CallConnect(conn)
+	openWebSocket(ctx, conn)
+	router.CallConnect(WebSocketConn(conn))
 }
 
 func (w wsEventHandler) OnDisconnect(ctx context.Context, conn *websocket.Conn, err error) {
-	router.CallDisconnect(conn, err)
+	closeWebSocket(conn)
+	router.CallDisconnect(WebSocketConn(conn), err)
 }
 
 func (w wsEventHandler) OnError(ctx context.Context, conn *websocket.Conn, err error) {
-	router.CallError(conn, err)
+	router.CallError(WebSocketConn(conn), err)
 }
 
 func (w wsEventHandler) OnMessage(ctx context.Context, conn *websocket.Conn, payload []byte) {
-	err := router.CallProcessMessage(conn, payload)
+	err := router.CallProcessMessage(WebSocketConn(conn), payload)
 	if err != nil {
-		router.CallError(conn, fmt.Errorf("unable to process message: %w", err))
+		router.CallError(WebSocketConn(conn), fmt.Errorf("unable to process message: %w", err))
 	}
 }
@@ -3,12 +3,57 @@ package typeid
 import (
 	"hash/fnv"
 	"reflect"
+	"sync"
 )
 
+// Named can be implemented by types that want an explicit network name.
+// The ID is then derived from the name instead of the package path, which keeps it
+// stable when the type is moved or renamed.
+type Named interface {
+	TypeName() string
+}
+
+var (
+	names      = make(map[reflect.Type]string)
+	namesMutex sync.RWMutex
+)
+
+// RegisterName overrides the name the ID of the type is derived from, this is an alternative to
+// implementing [Named] for types that cannot have methods added to them.
+func RegisterName(t reflect.Type, name string) {
+	namesMutex.Lock()
+	defer namesMutex.Unlock()
+
+	names[t] = name
+}
+
 // GetTypeId returns a hash based on the reflected type.
 // This is used to ensure consistent mappings across binaries.
 func GetTypeId(t reflect.Type) uint {
 	h := fnv.New64a()
-	_, _ = h.Write([]byte(t.String()))
+	_, _ = h.Write([]byte(TypeName(t)))
 	return uint(h.Sum64())
 }
+
+// TypeName returns the name the ID of the type is derived from.
+// This is the registered or [Named] name if there is one, otherwise it is the type name
+// qualified by its full package path, such as "github.com/leap-fish/necs/esync.NetworkId".
+func TypeName(t reflect.Type) string {
+	namesMutex.RLock()
+	name, ok := names[t]
+	namesMutex.RUnlock()
+	if ok {
+		return name
+	}
+
+	if named, ok := reflect.New(t).Interface().(Named); ok {
+		return named.TypeName()
+	}
+
+	// Unnamed types such as []int or map[string]int have no package path.
+	if t.Name() == "" || t.PkgPath() == "" {
+		return t.String()
+	}
+
+	return t.PkgPath() + "." + t.Name()
+}
@@ -1,10 +1,13 @@
 package typeid_test
 
 import (
-	"github.com/leap-fish/necs/typeid"
-	"github.com/stretchr/testify/assert"
+	htmltemplate "html/template"
 	"reflect"
 	"testing"
+	texttemplate "text/template"
+
+	"github.com/leap-fish/necs/typeid"
+	"github.com/stretchr/testify/assert"
 )
 
 type HealthComponent struct {
@@ -36,13 +39,13 @@ type testTuple struct {
 
 func TestGetTypeId_IsConsistent(t *testing.T) {
 	checkTypes := []testTuple{
-		{Test{}, 16851497653430128169},
-		{Test{}, 16851497653430128169},
-		{ColliderComponent{}, 13490843505570961692},
-		{ComplexComponent{}, 4386901356493381958},
-		{HealthComponent{}, 10689379905657179838},
-		{SimpleOne(444), 0xa09fb4b3f816b311},
-		{SimpleTwo(5659), 0xe8446db38ef98a1b},
+		{Test{}, 0xd56d7e8d98f2623e},
+		{Test{}, 0xd56d7e8d98f2623e},
+		{ColliderComponent{}, 0x4e2ea68752904da5},
+		{ComplexComponent{}, 0xa053062f6ff0ea85},
+		{HealthComponent{}, 0xf57b658b3388f2a3},
+		{SimpleOne(444), 0xc9ec8344a811dab0},
+		{SimpleTwo(5659), 0x81dbca4510d3e4c6},
 	}
 
 	type equalCheck struct {
@@ -66,3 +69,29 @@ func TestGetTypeId_IsConsistent(t *testing.T) {
 	}
 
 }
+
+type RenamedComponent struct{}
+
+func (RenamedComponent) TypeName() string { return "HealthComponent" }
+
+type RegisteredComponent struct{}
+
+func TestGetTypeId_PackageQualified(t *testing.T) {
+	// Both types are called "template.Template", only the package path tells them apart.
+	textId := typeid.GetTypeId(reflect.TypeOf(texttemplate.Template{}))
+	htmlId := typeid.GetTypeId(reflect.TypeOf(htmltemplate.Template{}))
+	assert.NotEqual(t, textId, htmlId)
+
+	assert.Equal(t, "github.com/leap-fish/necs/typeid_test.HealthComponent", typeid.TypeName(reflect.TypeOf(HealthComponent{})))
+	assert.Equal(t, "[]int", typeid.TypeName(reflect.TypeOf([]int{})))
+}
+
+func TestGetTypeId_ExplicitName(t *testing.T) {
+	assert.Equal(t, "HealthComponent", typeid.TypeName(reflect.TypeOf(RenamedComponent{})))
+
+	typeid.RegisterName(reflect.TypeOf(RegisteredComponent{}), "HealthComponent")
+	assert.Equal(t,
+		typeid.GetTypeId(reflect.TypeOf(RenamedComponent{})),
+		typeid.GetTypeId(reflect.TypeOf(RegisteredComponent{})),
+	)
+}
@@ -24,7 +24,7 @@ type ComponentMapper struct {
 	mutex sync.Mutex
 
 	typeToId      map[reflect.Type]uint8
-	idToComponent [math.MaxUint8]*interpolatedComponentData
+	idToComponent [math.MaxUint8 + 1]*interpolatedComponentData
 }
 
 func NewComponentMapper() *ComponentMapper {
@@ -36,6 +36,36 @@ func NewComponentMapper() *ComponentMapper {
 // RegisterInterpolatedComponent registers the given component and setter with
 // the provided ID, note that these IDs don't interfere with the normal esync.Register
 func (c *ComponentMapper) RegisterInterpolatedComponent(id uint8, comp donburi.IComponentType, lerp any) error {
+	if err := validateLerp(lerp); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.validate(id, comp); err != nil {
+		return err
+	}
+
+	c.idToComponent[id] = &interpolatedComponentData{
+		typ:    comp,
+		setter: reflect.ValueOf(lerp),
+	}
+	c.typeToId[comp.Typ()] = id
+
+	return nil
+}
+
+// ValidateInterpolatedComponent returns the error RegisterInterpolatedComponent would return for
+// the ID and component, without registering it.
+func (c *ComponentMapper) ValidateInterpolatedComponent(id uint8, comp donburi.IComponentType) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.validate(id, comp)
+}
+
+func validateLerp(lerp any) error {
 	if lerp == nil {
 		return fmt.Errorf("must provide lerp function: %w", ErrNilLerpFunction)
 	}
@@ -48,21 +78,29 @@ func (c *ComponentMapper) RegisterInterpolatedComponent(id uint8, comp donburi.I
 		return fmt.Errorf("lerp function must have 3 arguments: %w", ErrMalformedLerpFunction)
 	}
 
-	c.idToComponent[id] = &interpolatedComponentData{
-		typ:    comp,
-		setter: reflect.ValueOf(lerp),
-	}
+	return nil
+}
 
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+func (c *ComponentMapper) validate(id uint8, comp donburi.IComponentType) error {
+	if id == 0 {
+		return fmt.Errorf("interpolation id 0 is reserved: %w", ErrIdCollision)
+	}
 
-	c.typeToId[comp.Typ()] = id
+	if existing := c.idToComponent[id]; existing != nil && existing.typ.Typ() != comp.Typ() {
+		return fmt.Errorf("%w: interpolation id %d is reserved by %s", ErrIdCollision, id, existing.typ.Typ())
+	}
 
 	return nil
 }
 
+// LookupSetter returns the lerp function registered with the ID, or the zero
+// reflect.Value if there is none.
 func (c *ComponentMapper) LookupSetter(id uint8) reflect.Value {
-	return c.idToComponent[id].setter
+	data := c.idToComponent[id]
+	if data == nil {
+		return reflect.Value{}
+	}
+	return data.setter
 }
 
 func (c *ComponentMapper) RegisteredType(typ reflect.Type) bool {
@@ -78,7 +116,11 @@ func (c *ComponentMapper) RegisteredId(id uint8) bool {
 }
 
 func (c *ComponentMapper) LookupType(id uint8) reflect.Type {
-	return c.idToComponent[id].typ.Typ()
+	data := c.idToComponent[id]
+	if data == nil {
+		return nil
+	}
+	return data.typ.Typ()
 }
 
 func (c *ComponentMapper) LookupId(typ reflect.Type) uint8 {
@@ -0,0 +1,102 @@
+package typemapper
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var (
+	ErrUnknownVersion   = errors.New("unknown schema version")
+	ErrInvalidMigration = errors.New("invalid schema migration")
+)
+
+// Versioned can be implemented by registered types to declare their schema version.
+// Versioned types carry their version on the wire, which allows peers built against an
+// older version of the type to keep talking to the current one, see [TypeMapper.RegisterLegacy].
+//
+// Version 0 is reserved for unversioned types, so the first version should be 1.
+// Note that a type has to be versioned from the start, turning an existing unversioned
+// type into a versioned one changes its wire format.
+type Versioned interface {
+	SchemaVersion() uint
+}
+
+type legacySchema struct {
+	typ       reflect.Type
+	upgrade   func(any) any
+	downgrade func(any) any
+}
+
+// schemaVersion returns the declared schema version of the type, or 0 if it is not versioned.
+func schemaVersion(typ reflect.Type) uint {
+	versioned, ok := reflect.New(typ).Interface().(Versioned)
+	if !ok {
+		return 0
+	}
+
+	return versioned.SchemaVersion()
+}
+
+// RegisterLegacy registers an older schema version of the versioned type registered under id.
+// The legacy type describes the shape of the older version, upgrade converts a legacy value
+// to the current type and downgrade converts a current value back to the legacy type.
+func (db *TypeMapper) RegisterLegacy(id uint, version uint, legacy reflect.Type, upgrade func(any) any, downgrade func(any) any) error {
+	current := db.Version(id)
+	if current == 0 {
+		return fmt.Errorf("%w: type with id %d is not registered or not versioned", ErrInvalidMigration, id)
+	}
+
+	if version == 0 || version >= current {
+		return fmt.Errorf("%w: legacy version %d must be between 1 and %d", ErrInvalidMigration, version, current-1)
+	}
+
+	if upgrade == nil || downgrade == nil {
+		return fmt.Errorf("%w: upgrade and downgrade must be provided", ErrInvalidMigration)
+	}
+
+	db.mapMutex.Lock()
+	defer db.mapMutex.Unlock()
+
+	if db.legacy[id] == nil {
+		db.legacy[id] = make(map[uint]*legacySchema)
+	}
+
+	db.legacy[id][version] = &legacySchema{
+		typ:       legacy,
+		upgrade:   upgrade,
+		downgrade: downgrade,
+	}
+
+	return nil
+}
+
+// Version returns the current schema version of the type registered under id,
+// 0 means that the type is not versioned.
+func (db *TypeMapper) Version(id uint) uint {
+	db.mapMutex.Lock()
+	defer db.mapMutex.Unlock()
+
+	return db.versions[id]
+}
+
+// Manifest returns the current schema versions of all versioned types keyed by ID.
+// This is what a peer needs to know to send down-converted values with [TypeMapper.SerializeVersion].
+func (db *TypeMapper) Manifest() map[uint]uint {
+	db.mapMutex.Lock()
+	defer db.mapMutex.Unlock()
+
+	manifest := make(map[uint]uint, len(db.versions))
+	for id, version := range db.versions {
+		manifest[id] = version
+	}
+
+	return manifest
+}
+
+func (db *TypeMapper) lookupLegacy(id uint, version uint) *legacySchema {
+	db.mapMutex.Lock()
+	defer db.mapMutex.Unlock()
+
+	return db.legacy[id][version]
+}
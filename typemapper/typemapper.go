@@ -2,6 +2,7 @@ package typemapper
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"reflect"
 	"sync"
@@ -9,6 +10,8 @@ import (
 	"github.com/hashicorp/go-msgpack/v2/codec"
 )
 
+var ErrIdCollision = errors.New("type id collision")
+
 // TypeMapper is used to map between registered IDs and components and
 // translates between server and client components,
 // Note: Unexported members are supported,
@@ -17,6 +20,11 @@ type TypeMapper struct {
 	typeToId map[reflect.Type]uint
 	idToType map[uint]reflect.Type
 
+	// versions holds the current schema version of every registered type
+	// implementing Versioned, legacy holds the older versions it still understands.
+	versions map[uint]uint
+	legacy   map[uint]map[uint]*legacySchema
+
 	mapMutex sync.Mutex
 
 	handle *codec.MsgpackHandle
@@ -29,52 +37,74 @@ func NewMapper(components map[uint]any) TypeMapper {
 	typeToId := make(map[reflect.Type]uint, componentLen)
 	idToType := make(map[uint]reflect.Type, componentLen)
 
+	versions := make(map[uint]uint)
+
 	for id, instance := range components {
 		typeof := reflect.TypeOf(instance)
 		typeToId[typeof] = id
 		idToType[id] = typeof
+
+		if version := schemaVersion(typeof); version > 0 {
+			versions[id] = version
+		}
 	}
 
-	cdb := TypeMapper{
+	return TypeMapper{
 		typeToId: typeToId,
 		idToType: idToType,
+		versions: versions,
+		legacy:   make(map[uint]map[uint]*legacySchema),
 		handle:   &codec.MsgpackHandle{},
 	}
-
-	return cdb
 }
 
 // RegisterType registers a mapping based on ID and reflect.Type.
+// Registering the same type under the same ID again is a no-op, while registering
+// a different type under an ID that is already in use, or the same type under a
+// different ID, returns [ErrIdCollision].
 func (db *TypeMapper) RegisterType(id uint, componentType reflect.Type) error {
-
-	if db.idToType[id] != nil {
-		return fmt.Errorf("cannot register mapping for component %s with id %d because it is reserved", componentType, id)
-	}
-
 	db.mapMutex.Lock()
 	defer db.mapMutex.Unlock()
 
+	if existing := db.idToType[id]; existing != nil {
+		if existing == componentType {
+			return nil
+		}
+		return fmt.Errorf("%w: cannot register mapping for component %s with id %d because it is reserved by %s", ErrIdCollision, componentType, id, existing)
+	}
+	if existing, ok := db.typeToId[componentType]; ok {
+		return fmt.Errorf("%w: cannot register mapping for component %s with id %d because it is already registered with id %d", ErrIdCollision, componentType, id, existing)
+	}
+
 	db.typeToId[componentType] = id
 	db.idToType[id] = componentType
+	if version := schemaVersion(componentType); version > 0 {
+		db.versions[id] = version
+	}
 
 	return nil
 }
 
-// Register registers a mapping based on ID and an instance of the type.
-func (db *TypeMapper) Register(id uint, component any) error {
-	typeof := reflect.TypeOf(component)
-
-	if db.idToType[id] != nil {
-		return fmt.Errorf("cannot register mapping for component %s with id %d because it is reserved", typeof, id)
-	}
-
+// Unregister removes the mapping of the ID together with its schema versions, it is a no-op if
+// nothing is registered with the ID.
+func (db *TypeMapper) Unregister(id uint) {
 	db.mapMutex.Lock()
 	defer db.mapMutex.Unlock()
 
-	db.typeToId[typeof] = id
-	db.idToType[id] = typeof
+	componentType := db.idToType[id]
+	if componentType == nil {
+		return
+	}
 
-	return nil
+	delete(db.idToType, id)
+	delete(db.typeToId, componentType)
+	delete(db.versions, id)
+	delete(db.legacy, id)
+}
+
+// Register registers a mapping based on ID and an instance of the type.
+func (db *TypeMapper) Register(id uint, component any) error {
+	return db.RegisterType(id, reflect.TypeOf(component))
 }
 
 // Lookup finds the Type based on a component ID.
@@ -95,12 +125,32 @@ func (db *TypeMapper) LookupId(componentType reflect.Type) uint {
 
 // Serialize a component to bytes that can be networked.
 func (db *TypeMapper) Serialize(component any) ([]byte, error) {
+	return db.SerializeVersion(component, 0)
+}
+
+// SerializeVersion serializes a component for a peer that understands the given
+// schema version of it. A version of 0 or the current version serializes the
+// component as is, older versions are down-converted using the registered legacy schema.
+func (db *TypeMapper) SerializeVersion(component any, version uint) ([]byte, error) {
 	componentType := reflect.TypeOf(component)
 	id := db.LookupId(componentType)
 	if id == 0 {
 		return nil, fmt.Errorf("component ID not found for type %s; ensure it is registered with the component typemapper", componentType)
 	}
 
+	current := db.Version(id)
+	if version == 0 || version > current {
+		version = current
+	}
+
+	if version != current {
+		legacy := db.lookupLegacy(id, version)
+		if legacy == nil {
+			return nil, fmt.Errorf("%w: %s has no schema version %d", ErrUnknownVersion, componentType, version)
+		}
+		component = legacy.downgrade(component)
+	}
+
 	encodeBuf := &bytes.Buffer{}
 
 	encoder := codec.NewEncoder(encodeBuf, db.handle)
@@ -109,6 +159,12 @@ func (db *TypeMapper) Serialize(component any) ([]byte, error) {
 		return nil, err
 	}
 
+	if current > 0 {
+		if err := encoder.Encode(version); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := encoder.Encode(component); err != nil {
 		return nil, err
 	}
@@ -117,6 +173,7 @@ func (db *TypeMapper) Serialize(component any) ([]byte, error) {
 }
 
 // Deserialize a component by decoding its ID, and then the actual struct.
+// Components sent with an older schema version are upgraded to the current one.
 func (db *TypeMapper) Deserialize(data []byte) (any, error) {
 	decoder := codec.NewDecoderBytes(data, db.handle)
 
@@ -130,7 +187,35 @@ func (db *TypeMapper) Deserialize(data []byte) (any, error) {
 		return nil, fmt.Errorf("component type not found for ID %d", id)
 	}
 
-	instanced := reflect.New(component).Interface()
+	current := db.Version(id)
+	if current == 0 {
+		return db.decodeValue(decoder, component)
+	}
+
+	var version uint
+	if err := decoder.Decode(&version); err != nil {
+		return nil, err
+	}
+
+	if version == current {
+		return db.decodeValue(decoder, component)
+	}
+
+	legacy := db.lookupLegacy(id, version)
+	if legacy == nil {
+		return nil, fmt.Errorf("%w: %s has no schema version %d", ErrUnknownVersion, component, version)
+	}
+
+	value, err := db.decodeValue(decoder, legacy.typ)
+	if err != nil {
+		return nil, err
+	}
+
+	return legacy.upgrade(value), nil
+}
+
+func (db *TypeMapper) decodeValue(decoder *codec.Decoder, typ reflect.Type) (any, error) {
+	instanced := reflect.New(typ).Interface()
 	if err := decoder.Decode(instanced); err != nil {
 		return nil, err
 	}
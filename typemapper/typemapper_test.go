@@ -3,6 +3,8 @@ package typemapper_test
 import (
 	"github.com/leap-fish/necs/typemapper"
 	"github.com/stretchr/testify/assert"
+	"github.com/yohamta/donburi"
+	"math"
 	"reflect"
 	"testing"
 )
@@ -164,3 +166,112 @@ func BenchmarkTypeMapper_Serialize(b *testing.B) {
 		_, _ = mapper.Serialize(health)
 	}
 }
+
+type PlayerInfo struct {
+	Name  string
+	Level uint8
+}
+
+func (PlayerInfo) SchemaVersion() uint { return 2 }
+
+type PlayerInfoV1 struct {
+	Name string
+}
+
+func (PlayerInfoV1) SchemaVersion() uint { return 1 }
+
+func newVersionedMapper(t *testing.T) *typemapper.TypeMapper {
+	mapper := typemapper.NewMapper(map[uint]any{1: PlayerInfo{}})
+	err := mapper.RegisterLegacy(1, 1, reflect.TypeOf(PlayerInfoV1{}),
+		func(value any) any { return PlayerInfo{Name: value.(PlayerInfoV1).Name, Level: 1} },
+		func(value any) any { return PlayerInfoV1{Name: value.(PlayerInfo).Name} },
+	)
+	assert.Nil(t, err)
+	return &mapper
+}
+
+func TestTypeMapper_VersionedRoundTrip(t *testing.T) {
+	mapper := newVersionedMapper(t)
+	assert.Equal(t, uint(2), mapper.Version(1))
+	assert.Equal(t, map[uint]uint{1: 2}, mapper.Manifest())
+
+	serialized, err := mapper.Serialize(PlayerInfo{Name: "fish", Level: 5})
+	assert.Nil(t, err)
+
+	deserialized, err := mapper.Deserialize(serialized)
+	assert.Nil(t, err)
+	assert.Equal(t, PlayerInfo{Name: "fish", Level: 5}, deserialized)
+}
+
+func TestTypeMapper_VersionedDowngradeAndUpgrade(t *testing.T) {
+	mapper := newVersionedMapper(t)
+
+	downgraded, err := mapper.SerializeVersion(PlayerInfo{Name: "fish", Level: 5}, 1)
+	assert.Nil(t, err)
+
+	// An old peer only knows about the V1 shape.
+	oldPeer := typemapper.NewMapper(map[uint]any{1: PlayerInfoV1{}})
+
+	received, err := oldPeer.Deserialize(downgraded)
+	assert.Nil(t, err)
+	assert.Equal(t, PlayerInfoV1{Name: "fish"}, received)
+
+	sent, err := oldPeer.Serialize(PlayerInfoV1{Name: "goldie"})
+	assert.Nil(t, err)
+
+	upgraded, err := mapper.Deserialize(sent)
+	assert.Nil(t, err)
+	assert.Equal(t, PlayerInfo{Name: "goldie", Level: 1}, upgraded)
+}
+
+func TestTypeMapper_VersionedUnknownVersion(t *testing.T) {
+	mapper := typemapper.NewMapper(map[uint]any{1: PlayerInfo{}})
+
+	_, err := mapper.SerializeVersion(PlayerInfo{}, 1)
+	assert.ErrorIs(t, err, typemapper.ErrUnknownVersion)
+
+	err = mapper.RegisterLegacy(1, 2, reflect.TypeOf(PlayerInfoV1{}), func(a any) any { return a }, func(a any) any { return a })
+	assert.ErrorIs(t, err, typemapper.ErrInvalidMigration)
+}
+
+func TestTypeMapper_RegisterCollision(t *testing.T) {
+	mapper := typemapper.NewMapper(testComponentMapping)
+
+	// Registering the same mapping again is fine.
+	assert.Nil(t, mapper.RegisterType(1, reflect.TypeOf(HealthComponent{})))
+
+	err := mapper.RegisterType(1, reflect.TypeOf(ColliderComponent{}))
+	assert.ErrorIs(t, err, typemapper.ErrIdCollision)
+	assert.Equal(t, reflect.TypeOf(HealthComponent{}), mapper.Lookup(1))
+
+	// A type keeps the ID it was registered with.
+	err = mapper.RegisterType(100, reflect.TypeOf(HealthComponent{}))
+	assert.ErrorIs(t, err, typemapper.ErrIdCollision)
+	assert.Equal(t, uint(1), mapper.LookupId(reflect.TypeOf(HealthComponent{})))
+	assert.Nil(t, mapper.Lookup(100))
+}
+
+func TestComponentMapper_HighestId(t *testing.T) {
+	mapper := typemapper.NewComponentMapper()
+	ctype := donburi.NewComponentType[HealthComponent]()
+
+	// Keys received from the network can be any uint8.
+	assert.False(t, mapper.RegisteredId(math.MaxUint8))
+	assert.Nil(t, mapper.LookupType(math.MaxUint8))
+
+	lerp := func(from, to HealthComponent, delta float64) *HealthComponent { return &to }
+	assert.Nil(t, mapper.RegisterInterpolatedComponent(math.MaxUint8, ctype, lerp))
+	assert.Equal(t, reflect.TypeOf(HealthComponent{}), mapper.LookupType(math.MaxUint8))
+}
+
+func TestTypeMapper_Unregister(t *testing.T) {
+	mapper := typemapper.NewMapper(map[uint]any{1: PlayerInfo{}})
+
+	mapper.Unregister(1)
+	assert.Nil(t, mapper.Lookup(1))
+	assert.Zero(t, mapper.LookupId(reflect.TypeOf(PlayerInfo{})))
+	assert.Empty(t, mapper.Manifest())
+
+	// The ID and the type can be registered again.
+	assert.Nil(t, mapper.Register(2, PlayerInfo{}))
+}
@@ -0,0 +1,40 @@
+package wraptcp
+
+import (
+	"context"
+	"net"
+)
+
+type TCPClient struct {
+	options *Options
+	handler EventHandler
+}
+
+func NewTCPClient(handler EventHandler, options *Options) *TCPClient {
+	return &TCPClient{
+		options: options,
+		handler: handler,
+	}
+}
+
+// Dial connects to the address and handles the connection until it is closed.
+func (c *TCPClient) Dial(addr string, callback func(conn *Conn)) error {
+	netConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	err = c.options.configure(netConn)
+	if err != nil {
+		_ = netConn.Close()
+		return err
+	}
+
+	conn := newConn(netConn, c.options)
+	if callback != nil {
+		callback(conn)
+	}
+
+	readLoop(context.Background(), c.handler, conn)
+	return nil
+}
@@ -0,0 +1,188 @@
+package wraptcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// frameHeaderSize is the size of the big-endian length prefix of every frame.
+const frameHeaderSize = 4
+
+const (
+	DefaultMaxFrameSize = 1 << 20
+	DefaultKeepAlive    = time.Second * 15
+)
+
+var (
+	ErrFrameTooLarge = errors.New("frame exceeds the maximum frame size")
+)
+
+// Options configures TCP connections, a nil *Options uses the defaults.
+type Options struct {
+	// MaxFrameSize is the largest frame in bytes that is sent or accepted, larger frames
+	// close the connection. Defaults to [DefaultMaxFrameSize].
+	MaxFrameSize int
+	// KeepAlive is the period of the TCP keepalive probes, which detect peers that went away
+	// without closing the connection. Defaults to [DefaultKeepAlive], negative disables them.
+	KeepAlive time.Duration
+	// Delay enables Nagle's algorithm. By default TCP_NODELAY is set, so small messages such
+	// as inputs are sent immediately instead of being batched.
+	Delay bool
+}
+
+func (o *Options) maxFrameSize() int {
+	if o == nil || o.MaxFrameSize <= 0 {
+		return DefaultMaxFrameSize
+	}
+	return o.MaxFrameSize
+}
+
+// configure applies the socket options to the connection.
+func (o *Options) configure(conn net.Conn) error {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	var options Options
+	if o != nil {
+		options = *o
+	}
+
+	err := tcp.SetNoDelay(!options.Delay)
+	if err != nil {
+		return err
+	}
+
+	if options.KeepAlive < 0 {
+		return tcp.SetKeepAlive(false)
+	}
+	if options.KeepAlive == 0 {
+		options.KeepAlive = DefaultKeepAlive
+	}
+
+	return tcp.SetKeepAliveConfig(net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     options.KeepAlive,
+		Interval: options.KeepAlive,
+		Count:    3,
+	})
+}
+
+// Conn is a TCP connection that sends messages as length-prefixed frames.
+type Conn struct {
+	conn         net.Conn
+	reader       *bufio.Reader
+	maxFrameSize int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	writeMutex sync.Mutex
+}
+
+func newConn(conn net.Conn, options *Options) *Conn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Conn{
+		conn:         conn,
+		reader:       bufio.NewReader(conn),
+		maxFrameSize: options.maxFrameSize(),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// Write sends the payload as a single frame, the deadline of the context is used as the
+// write deadline. It is safe to call Write from multiple goroutines.
+func (c *Conn) Write(ctx context.Context, payload []byte) error {
+	if len(payload) > c.maxFrameSize {
+		return fmt.Errorf("%w: %d > %d", ErrFrameTooLarge, len(payload), c.maxFrameSize)
+	}
+
+	var header [frameHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	err := c.conn.SetWriteDeadline(deadline)
+	if err != nil {
+		return err
+	}
+
+	buffers := net.Buffers{header[:], payload}
+	_, err = buffers.WriteTo(c.conn)
+	return err
+}
+
+// read reads the next frame.
+func (c *Conn) read() ([]byte, error) {
+	var header [frameHeaderSize]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	if err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if uint64(size) > uint64(c.maxFrameSize) {
+		return nil, fmt.Errorf("%w: %d > %d", ErrFrameTooLarge, size, c.maxFrameSize)
+	}
+
+	payload := make([]byte, size)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	c.cancel()
+	return c.conn.Close()
+}
+
+// Context returns a context that is canceled once the connection is closed.
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// readLoop calls the handler for the connection until it is closed.
+func readLoop(ctx context.Context, handler EventHandler, conn *Conn) {
+	handler.OnConnect(ctx, conn)
+
+	var err error
+	for {
+		var payload []byte
+		payload, err = conn.read()
+		if err != nil {
+			break
+		}
+
+		handler.OnMessage(ctx, conn, payload)
+	}
+
+	// A closed connection is the normal way for it to end.
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		err = nil
+	} else {
+		handler.OnError(ctx, conn, err)
+	}
+
+	_ = conn.Close()
+	handler.OnDisconnect(ctx, conn, err)
+}
@@ -0,0 +1,115 @@
+package wraptcp_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/leap-fish/necs/wraptcp"
+	"github.com/stretchr/testify/assert"
+)
+
+// echoHandler reports the events on channels, and writes every message back if echo is set.
+type echoHandler struct {
+	echo        bool
+	messages    chan []byte
+	errors      chan error
+	disconnects chan error
+}
+
+func newEchoHandler(echo bool) *echoHandler {
+	return &echoHandler{
+		echo:        echo,
+		messages:    make(chan []byte, 16),
+		errors:      make(chan error, 16),
+		disconnects: make(chan error, 16),
+	}
+}
+
+func (h *echoHandler) OnConnect(ctx context.Context, conn *wraptcp.Conn) {}
+
+func (h *echoHandler) OnDisconnect(ctx context.Context, conn *wraptcp.Conn, err error) {
+	h.disconnects <- err
+}
+
+func (h *echoHandler) OnError(ctx context.Context, conn *wraptcp.Conn, err error) {
+	h.errors <- err
+}
+
+func (h *echoHandler) OnMessage(ctx context.Context, conn *wraptcp.Conn, payload []byte) {
+	h.messages <- payload
+	if h.echo {
+		_ = conn.Write(ctx, payload)
+	}
+}
+
+func startServer(t *testing.T, handler wraptcp.EventHandler, options *wraptcp.Options) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	server := wraptcp.NewTCPServer(handler, options)
+	go func() { _ = server.ServeListener(listener) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	return listener.Addr().String()
+}
+
+func receive[T any](t *testing.T, ch chan T) T {
+	select {
+	case value := <-ch:
+		return value
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out")
+	}
+	var zero T
+	return zero
+}
+
+func TestTCP_Echo(t *testing.T) {
+	serverHandler := newEchoHandler(true)
+	addr := startServer(t, serverHandler, nil)
+
+	clientHandler := newEchoHandler(false)
+	client := wraptcp.NewTCPClient(clientHandler, nil)
+
+	done := make(chan error, 1)
+	conns := make(chan *wraptcp.Conn, 1)
+	go func() {
+		done <- client.Dial(addr, func(conn *wraptcp.Conn) { conns <- conn })
+	}()
+	conn := receive(t, conns)
+
+	// Frames keep their boundaries, including empty ones.
+	for _, payload := range [][]byte{[]byte("hello"), {}, make([]byte, 70_000)} {
+		assert.Nil(t, conn.Write(context.Background(), payload))
+		assert.Equal(t, payload, receive(t, serverHandler.messages))
+		assert.Equal(t, payload, receive(t, clientHandler.messages))
+	}
+
+	assert.Nil(t, conn.Close())
+	assert.Nil(t, receive(t, serverHandler.disconnects))
+	assert.Nil(t, receive(t, done))
+}
+
+func TestTCP_MaxFrameSize(t *testing.T) {
+	serverHandler := newEchoHandler(true)
+	addr := startServer(t, serverHandler, &wraptcp.Options{MaxFrameSize: 8})
+
+	// The client allows larger frames than the server.
+	client := wraptcp.NewTCPClient(newEchoHandler(false), nil)
+	conns := make(chan *wraptcp.Conn, 1)
+	go func() { _ = client.Dial(addr, func(conn *wraptcp.Conn) { conns <- conn }) }()
+	conn := receive(t, conns)
+
+	assert.Nil(t, conn.Write(context.Background(), make([]byte, 9)))
+	assert.ErrorIs(t, receive(t, serverHandler.errors), wraptcp.ErrFrameTooLarge)
+	assert.ErrorIs(t, receive(t, serverHandler.disconnects), wraptcp.ErrFrameTooLarge)
+
+	limited := wraptcp.NewTCPClient(newEchoHandler(false), &wraptcp.Options{MaxFrameSize: 8})
+	go func() { _ = limited.Dial(addr, func(conn *wraptcp.Conn) { conns <- conn }) }()
+	conn = receive(t, conns)
+	defer conn.Close()
+
+	assert.ErrorIs(t, conn.Write(context.Background(), make([]byte, 9)), wraptcp.ErrFrameTooLarge)
+}
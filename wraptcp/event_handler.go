@@ -0,0 +1,12 @@
+package wraptcp
+
+import (
+	"context"
+)
+
+type EventHandler interface {
+	OnConnect(ctx context.Context, conn *Conn)
+	OnDisconnect(ctx context.Context, conn *Conn, err error)
+	OnError(ctx context.Context, conn *Conn, err error)
+	OnMessage(ctx context.Context, conn *Conn, payload []byte)
+}
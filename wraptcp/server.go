@@ -0,0 +1,70 @@
+package wraptcp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+type TCPServer struct {
+	options *Options
+	handler EventHandler
+
+	mutex    sync.Mutex
+	listener net.Listener
+}
+
+func NewTCPServer(handler EventHandler, options *Options) *TCPServer {
+	return &TCPServer{
+		options: options,
+		handler: handler,
+	}
+}
+
+// Serve listens on the address and handles connections until the server is closed.
+func (s *TCPServer) Serve(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.ServeListener(listener)
+}
+
+// ServeListener handles the connections of the listener until the server is closed.
+func (s *TCPServer) ServeListener(listener net.Listener) error {
+	s.mutex.Lock()
+	s.listener = listener
+	s.mutex.Unlock()
+
+	ctx := context.Background()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		err = s.options.configure(conn)
+		if err != nil {
+			_ = conn.Close()
+			continue
+		}
+
+		go readLoop(ctx, s.handler, newConn(conn, s.options))
+	}
+}
+
+// Close stops accepting connections, connections that have already been accepted are left open.
+func (s *TCPServer) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
@@ -0,0 +1,110 @@
+package wrapudp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// handshakeResend is how often handshake packets are sent until the server answers.
+const handshakeResend = time.Millisecond * 100
+
+type UDPClient struct {
+	options Options
+	handler EventHandler
+}
+
+func NewUDPClient(handler EventHandler, options *Options) *UDPClient {
+	return &UDPClient{
+		options: options.withDefaults(),
+		handler: handler,
+	}
+}
+
+// Dial connects to the address and handles the connection until it is closed.
+func (c *UDPClient) Dial(addr string, callback func(conn *Conn)) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	socket, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return err
+	}
+	defer socket.Close()
+
+	salt, err := c.handshake(socket)
+	if err != nil {
+		return err
+	}
+
+	conn := newConn(udpAddr, salt, func(packet []byte) error {
+		_, err := socket.Write(packet)
+		return err
+	}, func() {
+		_ = socket.Close()
+	}, c.options, c.handler)
+
+	if callback != nil {
+		callback(conn)
+	}
+	conn.start(context.Background())
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, err := socket.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			// Errors such as ICMP port unreachable are ignored, the connection times out
+			// if the server is really gone.
+			continue
+		}
+
+		packet := buf[:n]
+		if len(packet) > 0 && packetType(packet[0]) == packetAccept {
+			continue
+		}
+		conn.receive(packet)
+	}
+
+	<-conn.finished
+	return nil
+}
+
+// handshake requests a challenge from the server and answers it, until the server accepts the
+// connection or the handshake times out. It returns the salt of the session.
+func (c *UDPClient) handshake(socket *net.UDPConn) (uint64, error) {
+	deadline := time.Now().Add(c.options.HandshakeTimeout)
+	request := handshakePacket(packetConnect, nil)
+	var token []byte
+
+	buf := make([]byte, maxPacketSize)
+	for time.Now().Before(deadline) {
+		_, err := socket.Write(request)
+		if err != nil {
+			return 0, err
+		}
+
+		_ = socket.SetReadDeadline(time.Now().Add(handshakeResend))
+		n, err := socket.Read(buf)
+		if err != nil {
+			continue
+		}
+
+		packet := buf[:n]
+		switch {
+		case token == nil && n == 1+tokenSize && packetType(packet[0]) == packetChallenge:
+			// Later challenges are ignored, the session is salted with the token the server accepts.
+			token = append([]byte(nil), packet[1:]...)
+			request = handshakePacket(packetResponse, token)
+		case token != nil && n > 0 && (packetType(packet[0]) == packetAccept || packetType(packet[0]) == packetData):
+			return sessionSalt(token), socket.SetReadDeadline(time.Time{})
+		}
+	}
+
+	return 0, ErrHandshakeTimeout
+}
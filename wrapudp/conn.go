@@ -0,0 +1,688 @@
+package wrapudp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTimeout          = time.Second * 10
+	DefaultKeepAlive        = time.Second
+	DefaultHandshakeTimeout = time.Second * 5
+	DefaultMaxMessageSize   = fragmentSize * maxFragments
+
+	// tickInterval is how often acks, retransmissions and keepalives are sent.
+	tickInterval = time.Millisecond * 10
+	// sentWindow is the number of sent packets that are remembered to process their acks.
+	sentWindow = 1024
+	// maxReliableChunks limits the unacknowledged reliable chunks, the message ids in flight
+	// have to stay well within the receive window.
+	maxReliableChunks = 2048
+	// receiveWindow is the number of reliable unordered message ids that are remembered, it covers
+	// the ids in flight on both sides of the newest received id.
+	receiveWindow = 2 * maxReliableChunks
+	// maxAssemblies limits the partially received unreliable messages kept per connection.
+	maxAssemblies  = 64
+	minResendDelay = time.Millisecond * 50
+	initialRtt     = time.Millisecond * 100
+	inboxSize      = 1024
+)
+
+var (
+	ErrMessageTooLarge  = errors.New("message exceeds the maximum message size")
+	ErrSendBufferFull   = errors.New("too many unacknowledged reliable messages")
+	ErrTimeout          = errors.New("connection timed out")
+	ErrHandshakeTimeout = errors.New("handshake timed out")
+	ErrConnectionClosed = errors.New("connection closed")
+	ErrUnknownChannel   = errors.New("unknown channel")
+	ErrInboxFull        = errors.New("too many received messages waiting to be handled")
+)
+
+// Options configures UDP connections, a nil *Options uses the defaults.
+type Options struct {
+	// Timeout is how long a connection may go without receiving anything before it is closed.
+	// Defaults to [DefaultTimeout].
+	Timeout time.Duration
+	// KeepAlive is how often an empty packet is sent when there is nothing else to send.
+	// Defaults to [DefaultKeepAlive].
+	KeepAlive time.Duration
+	// HandshakeTimeout is how long a client tries to connect. Defaults to [DefaultHandshakeTimeout].
+	HandshakeTimeout time.Duration
+	// MaxMessageSize is the largest message in bytes that is sent or accepted, larger messages are
+	// fragmented into at most 255 packets. Defaults to [DefaultMaxMessageSize].
+	MaxMessageSize int
+
+	// drop is used by tests to simulate packet loss, packets it returns true for are not sent.
+	drop func(packet []byte) bool
+}
+
+func (o *Options) withDefaults() Options {
+	var options Options
+	if o != nil {
+		options = *o
+	}
+
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.KeepAlive <= 0 {
+		options.KeepAlive = DefaultKeepAlive
+	}
+	if options.HandshakeTimeout <= 0 {
+		options.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	if options.MaxMessageSize <= 0 || options.MaxMessageSize > DefaultMaxMessageSize {
+		options.MaxMessageSize = DefaultMaxMessageSize
+	}
+	return options
+}
+
+// outChunk is a reliable chunk that is waiting to be acknowledged.
+type outChunk struct {
+	chunk
+	acked  bool
+	sentAt time.Time
+}
+
+type sentPacket struct {
+	seq    uint16
+	valid  bool
+	acked  bool
+	sentAt time.Time
+	chunks []*outChunk
+}
+
+type assemblyKey struct {
+	channel Channel
+	id      uint16
+}
+
+type assembly struct {
+	fragments [][]byte
+	received  int
+	createdAt time.Time
+}
+
+// received is a message that is ready to be handled.
+type received struct {
+	channel Channel
+	data    []byte
+}
+
+type receivedId struct {
+	id  uint16
+	set bool
+}
+
+// Conn is a virtual connection to a peer over UDP. Messages are sent over channels with
+// different delivery guarantees, reliable messages are retransmitted until they are
+// acknowledged and messages larger than a packet are fragmented.
+type Conn struct {
+	addr    *net.UDPAddr
+	salt    uint64
+	write   func(packet []byte) error
+	onClose func()
+	options Options
+	handler EventHandler
+
+	inbox    chan []byte
+	done     chan struct{}
+	finished chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	mutex  sync.Mutex
+	closed bool
+	err    error
+
+	// Outgoing state.
+	localSeq uint16
+	sent     [sentWindow]sentPacket
+	nextId   [channelCount]uint16
+	reliable []*outChunk
+	lastSend time.Time
+	rtt      time.Duration
+
+	// Incoming state.
+	remoteSeq       uint16
+	receivedAny     bool
+	ackBits         uint32
+	ackPending      bool
+	lastReceive     time.Time
+	assemblies      map[assemblyKey]*assembly
+	orderedNext     uint16
+	orderedBuffer   map[uint16][]byte
+	unorderedSeen   [receiveWindow]receivedId
+	unorderedLatest uint16
+	sequencedLatest uint16
+	sequencedAny    bool
+}
+
+func newConn(addr *net.UDPAddr, salt uint64, write func([]byte) error, onClose func(), options Options, handler EventHandler) *Conn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Conn{
+		addr:          addr,
+		salt:          salt,
+		write:         write,
+		onClose:       onClose,
+		options:       options,
+		handler:       handler,
+		inbox:         make(chan []byte, inboxSize),
+		done:          make(chan struct{}),
+		finished:      make(chan struct{}),
+		ctx:           ctx,
+		cancel:        cancel,
+		rtt:           initialRtt,
+		lastReceive:   time.Now(),
+		assemblies:    make(map[assemblyKey]*assembly),
+		orderedBuffer: make(map[uint16][]byte),
+	}
+}
+
+// start runs the event handler and the timers of the connection.
+func (c *Conn) start(ctx context.Context) {
+	go c.run(ctx)
+	go c.tickLoop()
+}
+
+// Write sends the payload over the [ReliableOrdered] channel.
+func (c *Conn) Write(ctx context.Context, payload []byte) error {
+	return c.WriteChannel(ctx, ReliableOrdered, payload)
+}
+
+// WriteChannel sends the payload over the given channel. It is safe to call from multiple
+// goroutines and never blocks on the peer, reliable messages are kept until they are
+// acknowledged.
+func (c *Conn) WriteChannel(ctx context.Context, channel Channel, payload []byte) error {
+	if channel >= channelCount {
+		return fmt.Errorf("%w: %d", ErrUnknownChannel, channel)
+	}
+	if len(payload) > c.options.MaxMessageSize {
+		return fmt.Errorf("%w: %d > %d", ErrMessageTooLarge, len(payload), c.options.MaxMessageSize)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return ErrConnectionClosed
+	}
+
+	if channel.reliable() {
+		// The payload is referenced until it is acknowledged, so it is copied.
+		payload = append([]byte(nil), payload...)
+	}
+
+	chunks := split(channel, c.nextId[channel], payload)
+	if channel.reliable() {
+		if len(c.reliable)+len(chunks) > maxReliableChunks {
+			return ErrSendBufferFull
+		}
+
+		for _, chunk := range chunks {
+			c.reliable = append(c.reliable, &outChunk{chunk: chunk})
+		}
+		chunks = nil
+	}
+	c.nextId[channel]++
+
+	return c.flush(chunks, false, time.Now())
+}
+
+// flush sends the given unreliable chunks together with the reliable chunks that are due.
+// If force is set, a packet is sent even if there is nothing to send, to deliver acks.
+func (c *Conn) flush(unreliable []chunk, force bool, now time.Time) error {
+	resendDelay := max(c.rtt*2, minResendDelay)
+
+	var packet []byte
+	var packetChunks []*outChunk
+	send := func() error {
+		c.sent[c.localSeq%sentWindow] = sentPacket{seq: c.localSeq, valid: true, sentAt: now, chunks: packetChunks}
+		c.localSeq++
+		c.lastSend = now
+		c.ackPending = false
+
+		err := c.send(packet)
+		packet, packetChunks = nil, nil
+		return err
+	}
+	add := func(ch *chunk) error {
+		if packet != nil && len(packet)+ch.size() > maxPacketSize {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+		if packet == nil {
+			packet = c.header().append(make([]byte, 0, maxPacketSize))
+		}
+		packet = ch.append(packet)
+		return nil
+	}
+
+	for _, out := range c.reliable {
+		if out.acked || (!out.sentAt.IsZero() && now.Sub(out.sentAt) < resendDelay) {
+			continue
+		}
+		if err := add(&out.chunk); err != nil {
+			return err
+		}
+		out.sentAt = now
+		packetChunks = append(packetChunks, out)
+	}
+	for i := range unreliable {
+		if err := add(&unreliable[i]); err != nil {
+			return err
+		}
+	}
+
+	if packet == nil && force {
+		packet = c.header().append(make([]byte, 0, packetHeaderSize))
+	}
+	if packet == nil {
+		return nil
+	}
+	return send()
+}
+
+func (c *Conn) header() packetHeader {
+	return packetHeader{salt: c.salt, seq: c.localSeq, ack: c.remoteSeq, ackBits: c.ackBits}
+}
+
+func (c *Conn) send(packet []byte) error {
+	if c.options.drop != nil && c.options.drop(packet) {
+		return nil
+	}
+	return c.write(packet)
+}
+
+// receive handles a packet received from the peer. Packets without the salt of the session
+// are ignored.
+func (c *Conn) receive(packet []byte) {
+	if salt, ok := readSalt(packet); !ok || salt != c.salt {
+		return
+	}
+
+	switch packetType(packet[0]) {
+	case packetDisconnect:
+		c.close(nil)
+	case packetData:
+		for _, message := range c.receiveData(packet) {
+			// The read loop of the server is shared by all connections, so it never waits for
+			// a slow handler. Reliable messages are already acked, so they cannot be dropped.
+			select {
+			case c.inbox <- message.data:
+			default:
+				if message.channel.reliable() {
+					c.disconnect(ErrInboxFull)
+					return
+				}
+			}
+		}
+	}
+}
+
+// receiveData processes the header and chunks of a data packet, and returns the messages
+// that are ready to be delivered.
+func (c *Conn) receiveData(packet []byte) []received {
+	header, body, ok := readPacketHeader(packet)
+	if !ok {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	now := time.Now()
+	c.lastReceive = now
+	c.processAcks(header, now)
+
+	if !c.receiveSeq(header.seq) {
+		// A duplicated packet, it still has to be acked again.
+		c.ackPending = true
+		return nil
+	}
+
+	var messages []received
+	for len(body) > 0 {
+		var ch chunk
+		ch, body, ok = readChunk(body)
+		if !ok {
+			break
+		}
+
+		c.ackPending = true
+		if data, complete := c.assemble(ch, now); complete {
+			for _, message := range c.deliver(nil, ch.channel, ch.id, data) {
+				messages = append(messages, received{channel: ch.channel, data: message})
+			}
+		}
+	}
+	return messages
+}
+
+// receiveSeq updates the acks for the received packet, it returns false if the packet is a duplicate.
+func (c *Conn) receiveSeq(seq uint16) bool {
+	if !c.receivedAny {
+		c.receivedAny = true
+		c.remoteSeq = seq
+		return true
+	}
+
+	if seqGreater(seq, c.remoteSeq) {
+		shift := seq - c.remoteSeq
+		switch {
+		case shift < 32:
+			c.ackBits = c.ackBits<<shift | 1<<(shift-1)
+		case shift == 32:
+			c.ackBits = 1 << 31
+		default:
+			c.ackBits = 0
+		}
+		c.remoteSeq = seq
+		return true
+	}
+
+	if seq == c.remoteSeq {
+		return false
+	}
+
+	diff := c.remoteSeq - seq
+	if diff > 32 {
+		// Too old to tell, reliable chunks are deduplicated by their message id.
+		return true
+	}
+
+	bit := uint32(1) << (diff - 1)
+	if c.ackBits&bit != 0 {
+		return false
+	}
+	c.ackBits |= bit
+	return true
+}
+
+func (c *Conn) processAcks(header packetHeader, now time.Time) {
+	c.ackPacket(header.ack, now)
+	for i := uint16(0); i < 32; i++ {
+		if header.ackBits&(1<<i) != 0 {
+			c.ackPacket(header.ack-1-i, now)
+		}
+	}
+
+	// Drop the acknowledged chunks from the send buffer.
+	remaining := c.reliable[:0]
+	for _, out := range c.reliable {
+		if !out.acked {
+			remaining = append(remaining, out)
+		}
+	}
+	clear(c.reliable[len(remaining):])
+	c.reliable = remaining
+}
+
+func (c *Conn) ackPacket(seq uint16, now time.Time) {
+	sent := &c.sent[seq%sentWindow]
+	if !sent.valid || sent.seq != seq || sent.acked {
+		return
+	}
+
+	sent.acked = true
+	c.rtt = c.rtt*7/8 + now.Sub(sent.sentAt)/8
+	for _, out := range sent.chunks {
+		out.acked = true
+	}
+	sent.chunks = nil
+}
+
+// assemble collects the fragments of a message, it returns the message once all of its
+// fragments have been received.
+func (c *Conn) assemble(ch chunk, now time.Time) ([]byte, bool) {
+	if ch.channel.reliable() && (c.delivered(ch.channel, ch.id) || !c.inWindow(ch.channel, ch.id)) {
+		return nil, false
+	}
+
+	// The chunk is part of the packet buffer, so it is copied before it is kept.
+	if ch.count == 1 {
+		return append([]byte(nil), ch.data...), true
+	}
+
+	// Drop messages that can only be larger than the maximum message size.
+	if int(ch.count-1)*fragmentSize >= c.options.MaxMessageSize {
+		return nil, false
+	}
+
+	key := assemblyKey{channel: ch.channel, id: ch.id}
+	message, ok := c.assemblies[key]
+	if !ok {
+		if !ch.channel.reliable() && len(c.assemblies) >= maxAssemblies {
+			c.dropOldestAssembly()
+		}
+		message = &assembly{fragments: make([][]byte, ch.count), createdAt: now}
+		c.assemblies[key] = message
+	}
+
+	if int(ch.index) >= len(message.fragments) || message.fragments[ch.index] != nil {
+		return nil, false
+	}
+	message.fragments[ch.index] = append([]byte(nil), ch.data...)
+	message.received++
+
+	if message.received < len(message.fragments) {
+		return nil, false
+	}
+
+	delete(c.assemblies, key)
+	var data []byte
+	for _, fragment := range message.fragments {
+		data = append(data, fragment...)
+	}
+	return data, true
+}
+
+func (c *Conn) dropOldestAssembly() {
+	var oldestKey assemblyKey
+	var oldest *assembly
+	for key, message := range c.assemblies {
+		if key.channel.reliable() {
+			continue
+		}
+		if oldest == nil || message.createdAt.Before(oldest.createdAt) {
+			oldestKey, oldest = key, message
+		}
+	}
+	if oldest != nil {
+		delete(c.assemblies, oldestKey)
+	}
+}
+
+// delivered returns true if the reliable message has already been received.
+func (c *Conn) delivered(channel Channel, id uint16) bool {
+	switch channel {
+	case ReliableOrdered:
+		if _, buffered := c.orderedBuffer[id]; buffered {
+			return true
+		}
+		return !seqGreater(id, c.orderedNext) && id != c.orderedNext
+	case ReliableUnordered:
+		seen := c.unorderedSeen[id%receiveWindow]
+		return seen.set && seen.id == id
+	}
+	return false
+}
+
+// inWindow returns false if the reliable message is further from the messages received so far
+// than the sender can have in flight. Such messages are dropped, so a peer cannot make the
+// connection buffer or assemble an unlimited number of messages.
+func (c *Conn) inWindow(channel Channel, id uint16) bool {
+	switch channel {
+	case ReliableOrdered:
+		return id-c.orderedNext < maxReliableChunks
+	case ReliableUnordered:
+		return id-c.unorderedLatest+maxReliableChunks < receiveWindow
+	}
+	return true
+}
+
+// deliver appends the message to the messages that are ready, according to its channel.
+func (c *Conn) deliver(messages [][]byte, channel Channel, id uint16, data []byte) [][]byte {
+	switch channel {
+	case ReliableOrdered:
+		if id != c.orderedNext {
+			c.orderedBuffer[id] = data
+			return messages
+		}
+
+		messages = append(messages, data)
+		c.orderedNext++
+		for {
+			next, ok := c.orderedBuffer[c.orderedNext]
+			if !ok {
+				return messages
+			}
+			delete(c.orderedBuffer, c.orderedNext)
+			messages = append(messages, next)
+			c.orderedNext++
+		}
+	case ReliableUnordered:
+		c.unorderedSeen[id%receiveWindow] = receivedId{id: id, set: true}
+		if seqGreater(id, c.unorderedLatest) {
+			c.unorderedLatest = id
+		}
+	case UnreliableSequenced:
+		if c.sequencedAny && !seqGreater(id, c.sequencedLatest) {
+			return messages
+		}
+		c.sequencedAny = true
+		c.sequencedLatest = id
+	}
+
+	return append(messages, data)
+}
+
+func (c *Conn) tickLoop() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.tick(now)
+		}
+	}
+}
+
+func (c *Conn) tick(now time.Time) {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return
+	}
+
+	if now.Sub(c.lastReceive) > c.options.Timeout {
+		c.mutex.Unlock()
+		c.close(ErrTimeout)
+		return
+	}
+
+	for key, message := range c.assemblies {
+		if !key.channel.reliable() && now.Sub(message.createdAt) > c.options.Timeout {
+			delete(c.assemblies, key)
+		}
+	}
+
+	force := c.ackPending || now.Sub(c.lastSend) >= c.options.KeepAlive
+	err := c.flush(nil, force, now)
+	c.mutex.Unlock()
+
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		c.handler.OnError(context.Background(), c, err)
+	}
+}
+
+// run calls the event handler of the connection until it is closed.
+func (c *Conn) run(ctx context.Context) {
+	defer close(c.finished)
+
+	c.handler.OnConnect(ctx, c)
+	for {
+		select {
+		case payload := <-c.inbox:
+			c.handler.OnMessage(ctx, c, payload)
+		case <-c.done:
+			if c.err != nil {
+				c.handler.OnError(ctx, c, c.err)
+			}
+			c.handler.OnDisconnect(ctx, c, c.err)
+			return
+		}
+	}
+}
+
+// close closes the connection without telling the peer.
+func (c *Conn) close(err error) {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return
+	}
+	c.closed = true
+	c.err = err
+	close(c.done)
+	c.cancel()
+	c.mutex.Unlock()
+
+	if c.onClose != nil {
+		c.onClose()
+	}
+}
+
+// Close tells the peer the connection is closed and closes it. As the disconnect packet might
+// get lost, the peer could also only find out by timing out.
+func (c *Conn) Close() error {
+	c.disconnect(nil)
+	return nil
+}
+
+// disconnect tells the peer the connection is closed and closes it with the error.
+func (c *Conn) disconnect(err error) {
+	c.mutex.Lock()
+	closed := c.closed
+	c.mutex.Unlock()
+	if closed {
+		return
+	}
+
+	packet := binary.BigEndian.AppendUint64([]byte{byte(packetDisconnect)}, c.salt)
+	for i := 0; i < 3; i++ {
+		_ = c.send(packet)
+	}
+	c.close(err)
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// Context returns a context that is canceled once the connection is closed.
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// RTT returns the smoothed round trip time to the peer.
+func (c *Conn) RTT() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.rtt
+}
@@ -0,0 +1,273 @@
+package wrapudp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder reports the events of connections on channels, and writes every message back if echo is set.
+type recorder struct {
+	echo        bool
+	connects    chan *Conn
+	messages    chan []byte
+	disconnects chan error
+}
+
+func newRecorder(echo bool) *recorder {
+	return &recorder{
+		echo:        echo,
+		connects:    make(chan *Conn, 16),
+		messages:    make(chan []byte, 1024),
+		disconnects: make(chan error, 16),
+	}
+}
+
+func (r *recorder) OnConnect(ctx context.Context, conn *Conn) {
+	r.connects <- conn
+}
+
+func (r *recorder) OnDisconnect(ctx context.Context, conn *Conn, err error) {
+	r.disconnects <- err
+}
+
+func (r *recorder) OnError(ctx context.Context, conn *Conn, err error) {}
+
+func (r *recorder) OnMessage(ctx context.Context, conn *Conn, payload []byte) {
+	r.messages <- payload
+	if r.echo {
+		_ = conn.Write(ctx, payload)
+	}
+}
+
+func receive[T any](t *testing.T, ch chan T) T {
+	t.Helper()
+
+	select {
+	case value := <-ch:
+		return value
+	case <-time.After(time.Second * 10):
+		t.Fatal("timed out")
+	}
+	var zero T
+	return zero
+}
+
+// lossy drops about the given share of packets.
+func lossy(share float64) func([]byte) bool {
+	var mutex sync.Mutex
+	random := rand.New(rand.NewSource(1))
+	return func([]byte) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return random.Float64() < share
+	}
+}
+
+func startServer(t *testing.T, handler EventHandler, options *Options) (*UDPServer, string) {
+	socket, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+
+	server := NewUDPServer(handler, options)
+	go func() { _ = server.ServeConn(socket) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	return server, socket.LocalAddr().String()
+}
+
+func dial(t *testing.T, addr string, handler EventHandler, options *Options) (*Conn, chan error) {
+	conns := make(chan *Conn, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- NewUDPClient(handler, options).Dial(addr, func(conn *Conn) { conns <- conn })
+	}()
+
+	select {
+	case conn := <-conns:
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn, done
+	case err := <-done:
+		t.Fatalf("unable to dial: %s", err)
+	}
+	return nil, nil
+}
+
+func TestUDP_ReliableOrdered(t *testing.T) {
+	serverRecorder := newRecorder(true)
+	_, addr := startServer(t, serverRecorder, &Options{drop: lossy(0.3)})
+
+	clientRecorder := newRecorder(false)
+	conn, _ := dial(t, addr, clientRecorder, &Options{drop: lossy(0.3)})
+	receive(t, serverRecorder.connects)
+
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, conn.Write(context.Background(), []byte{byte(i)}))
+	}
+
+	// Despite the packet loss, every message arrives once and in order, in both directions.
+	for i := 0; i < 200; i++ {
+		assert.Equal(t, []byte{byte(i)}, receive(t, serverRecorder.messages))
+		assert.Equal(t, []byte{byte(i)}, receive(t, clientRecorder.messages))
+	}
+}
+
+func TestUDP_Fragmentation(t *testing.T) {
+	serverRecorder := newRecorder(false)
+	_, addr := startServer(t, serverRecorder, &Options{drop: lossy(0.1)})
+	conn, _ := dial(t, addr, newRecorder(false), &Options{drop: lossy(0.1)})
+
+	payload := make([]byte, 100_000)
+	_, _ = rand.New(rand.NewSource(2)).Read(payload)
+
+	assert.Nil(t, conn.WriteChannel(context.Background(), ReliableUnordered, payload))
+	assert.True(t, bytes.Equal(payload, receive(t, serverRecorder.messages)))
+
+	assert.ErrorIs(t, conn.Write(context.Background(), make([]byte, DefaultMaxMessageSize+1)), ErrMessageTooLarge)
+}
+
+func TestUDP_Unreliable(t *testing.T) {
+	serverRecorder := newRecorder(false)
+	_, addr := startServer(t, serverRecorder, nil)
+	conn, _ := dial(t, addr, newRecorder(false), nil)
+
+	assert.Nil(t, conn.WriteChannel(context.Background(), Unreliable, []byte("snapshot")))
+	assert.Equal(t, []byte("snapshot"), receive(t, serverRecorder.messages))
+}
+
+func TestUDP_Disconnect(t *testing.T) {
+	serverRecorder := newRecorder(false)
+	_, addr := startServer(t, serverRecorder, nil)
+	conn, done := dial(t, addr, newRecorder(false), nil)
+	receive(t, serverRecorder.connects)
+
+	assert.Nil(t, conn.Close())
+	assert.Nil(t, receive(t, serverRecorder.disconnects))
+	assert.Nil(t, receive(t, done))
+}
+
+func TestUDP_Timeout(t *testing.T) {
+	serverRecorder := newRecorder(false)
+	_, addr := startServer(t, serverRecorder, &Options{Timeout: time.Millisecond * 200})
+
+	// The client stops sending anything, so the server times out.
+	clientRecorder := newRecorder(false)
+	_, _ = dial(t, addr, clientRecorder, &Options{drop: func([]byte) bool { return true }})
+
+	receive(t, serverRecorder.connects)
+	assert.ErrorIs(t, receive(t, serverRecorder.disconnects), ErrTimeout)
+}
+
+func TestUDP_HandshakeTimeout(t *testing.T) {
+	// Nothing is listening, so the handshake never completes.
+	socket, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Nil(t, err)
+	defer socket.Close()
+
+	err = NewUDPClient(newRecorder(false), &Options{HandshakeTimeout: time.Millisecond * 300}).Dial(socket.LocalAddr().String(), nil)
+	assert.ErrorIs(t, err, ErrHandshakeTimeout)
+}
+
+func TestChallengeToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	token := challengeToken(secret, "127.0.0.1:1000", now)
+
+	assert.True(t, validToken(secret, "127.0.0.1:1000", token, now))
+	// A token is only valid for the address it was sent to, and only for a while.
+	assert.False(t, validToken(secret, "127.0.0.1:1001", token, now))
+	assert.False(t, validToken([]byte("other"), "127.0.0.1:1000", token, now))
+	assert.False(t, validToken(secret, "127.0.0.1:1000", token, now.Add(tokenLifetime*2)))
+}
+
+func TestConn_SequencedDropsStale(t *testing.T) {
+	conn := newConn(nil, 0, nil, nil, (*Options)(nil).withDefaults(), newRecorder(false))
+
+	messages := conn.deliver(nil, UnreliableSequenced, 2, []byte{2})
+	messages = conn.deliver(messages, UnreliableSequenced, 1, []byte{1})
+	messages = conn.deliver(messages, UnreliableSequenced, 3, []byte{3})
+
+	assert.Equal(t, [][]byte{{2}, {3}}, messages)
+}
+
+func TestConn_ReliableWindow(t *testing.T) {
+	conn := newConn(nil, 0, nil, nil, (*Options)(nil).withDefaults(), newRecorder(false))
+	now := time.Now()
+
+	// Messages further ahead than the sender can have in flight are neither buffered nor assembled.
+	_, complete := conn.assemble(chunk{channel: ReliableOrdered, id: maxReliableChunks, count: 1, data: []byte{1}}, now)
+	assert.False(t, complete)
+	_, complete = conn.assemble(chunk{channel: ReliableOrdered, id: maxReliableChunks, count: 2, data: []byte{1}}, now)
+	assert.False(t, complete)
+	assert.Empty(t, conn.assemblies)
+
+	data, complete := conn.assemble(chunk{channel: ReliableOrdered, id: maxReliableChunks - 1, count: 1, data: []byte{1}}, now)
+	assert.True(t, complete)
+	assert.Empty(t, conn.deliver(nil, ReliableOrdered, maxReliableChunks-1, data))
+	assert.Len(t, conn.orderedBuffer, 1)
+
+	// Unordered messages are limited on both sides of the newest received one.
+	conn.deliver(nil, ReliableUnordered, 3000, []byte{1})
+	for id, accepted := range map[uint16]bool{
+		3000 + maxReliableChunks - 1: true,
+		3000 + maxReliableChunks:     false,
+		3000 - maxReliableChunks:     true,
+		3000 - maxReliableChunks - 1: false,
+	} {
+		_, complete = conn.assemble(chunk{channel: ReliableUnordered, id: id, count: 2, data: []byte{1}}, now)
+		assert.False(t, complete)
+		_, assembling := conn.assemblies[assemblyKey{channel: ReliableUnordered, id: id}]
+		assert.Equal(t, accepted, assembling, id)
+	}
+}
+
+func TestConn_ReceiveSeq(t *testing.T) {
+	conn := newConn(nil, 0, nil, nil, (*Options)(nil).withDefaults(), newRecorder(false))
+
+	assert.True(t, conn.receiveSeq(65535))
+	assert.True(t, conn.receiveSeq(1))
+	assert.True(t, conn.receiveSeq(0))
+	assert.False(t, conn.receiveSeq(0))
+	assert.False(t, conn.receiveSeq(1))
+
+	// Packets 0 and 65535 are acked by the bits of packet 1.
+	assert.Equal(t, uint16(1), conn.remoteSeq)
+	assert.Equal(t, uint32(0b11), conn.ackBits)
+}
+
+func TestConn_ReceiveWrongSalt(t *testing.T) {
+	conn := newConn(nil, 1, nil, nil, (*Options)(nil).withDefaults(), newRecorder(false))
+
+	// Disconnect packets from a spoofed address do not know the salt of the session.
+	conn.receive([]byte{byte(packetDisconnect)})
+	conn.receive(binary.BigEndian.AppendUint64([]byte{byte(packetDisconnect)}, 2))
+	assert.Nil(t, conn.Context().Err())
+
+	conn.receive(binary.BigEndian.AppendUint64([]byte{byte(packetDisconnect)}, 1))
+	assert.NotNil(t, conn.Context().Err())
+}
+
+func TestConn_InboxFull(t *testing.T) {
+	conn := newConn(nil, 1, func([]byte) error { return nil }, nil, (*Options)(nil).withDefaults(), newRecorder(false))
+
+	packet := func(seq uint16, channel Channel) []byte {
+		ch := chunk{channel: channel, id: seq, count: 1, data: []byte{1}}
+		return ch.append(packetHeader{salt: 1, seq: seq}.append(nil))
+	}
+
+	// Nothing handles the messages, but receiving never blocks. Unreliable messages are dropped.
+	for i := 0; i <= inboxSize; i++ {
+		conn.receive(packet(uint16(i), Unreliable))
+	}
+	assert.Len(t, conn.inbox, inboxSize)
+	assert.Nil(t, conn.Context().Err())
+
+	conn.receive(packet(0, ReliableOrdered))
+	assert.ErrorIs(t, conn.err, ErrInboxFull)
+}
@@ -0,0 +1,14 @@
+package wrapudp
+
+import (
+	"context"
+)
+
+// EventHandler is called for the events of a connection. The callbacks of a connection are
+// called from a single goroutine, in order.
+type EventHandler interface {
+	OnConnect(ctx context.Context, conn *Conn)
+	OnDisconnect(ctx context.Context, conn *Conn, err error)
+	OnError(ctx context.Context, conn *Conn, err error)
+	OnMessage(ctx context.Context, conn *Conn, payload []byte)
+}
@@ -0,0 +1,212 @@
+package wrapudp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+)
+
+// Channel is the delivery guarantee of a message, the channels match those of the router.
+type Channel uint8
+
+const (
+	ReliableOrdered Channel = iota
+	ReliableUnordered
+	Unreliable
+	UnreliableSequenced
+
+	channelCount
+)
+
+func (c Channel) reliable() bool {
+	return c == ReliableOrdered || c == ReliableUnordered
+}
+
+type packetType uint8
+
+const (
+	packetConnect packetType = iota + 1
+	packetChallenge
+	packetResponse
+	packetAccept
+	packetData
+	packetDisconnect
+)
+
+const (
+	// maxPacketSize keeps datagrams below the minimum MTU of most paths, so they are not
+	// fragmented by IP.
+	maxPacketSize = 1200
+	// protocolId is sent in the handshake, so datagrams of other protocols are ignored.
+	protocolId = 0x6e656373
+
+	// handshakePacketSize is the size connect and response packets are padded to, the server
+	// never answers with more data than it received, so it cannot be used for amplification.
+	handshakePacketSize = 64
+	tokenSize           = 8 + 16
+	tokenLifetime       = time.Second * 10
+	saltSize            = 8
+
+	// type, salt, sequence, ack and ack bits
+	packetHeaderSize = 1 + saltSize + 2 + 2 + 4
+	// channel, message id, fragment index, fragment count and length
+	chunkHeaderSize = 1 + 2 + 1 + 1 + 2
+	fragmentSize    = maxPacketSize - packetHeaderSize - chunkHeaderSize
+	maxFragments    = 255
+)
+
+// seqGreater returns true if a is newer than b, taking wrap-around into account.
+func seqGreater(a, b uint16) bool {
+	return a != b && a-b < 1<<15
+}
+
+// packetHeader is the header of data packets. Salt identifies the session, see [sessionSalt].
+// Ack is the newest packet received from the peer, and bit n of AckBits tells whether packet
+// Ack-1-n has been received.
+type packetHeader struct {
+	salt    uint64
+	seq     uint16
+	ack     uint16
+	ackBits uint32
+}
+
+func (h packetHeader) append(buf []byte) []byte {
+	buf = append(buf, byte(packetData))
+	buf = binary.BigEndian.AppendUint64(buf, h.salt)
+	buf = binary.BigEndian.AppendUint16(buf, h.seq)
+	buf = binary.BigEndian.AppendUint16(buf, h.ack)
+	return binary.BigEndian.AppendUint32(buf, h.ackBits)
+}
+
+func readPacketHeader(packet []byte) (packetHeader, []byte, bool) {
+	if len(packet) < packetHeaderSize {
+		return packetHeader{}, nil, false
+	}
+
+	return packetHeader{
+		salt:    binary.BigEndian.Uint64(packet[1:]),
+		seq:     binary.BigEndian.Uint16(packet[9:]),
+		ack:     binary.BigEndian.Uint16(packet[11:]),
+		ackBits: binary.BigEndian.Uint32(packet[13:]),
+	}, packet[packetHeaderSize:], true
+}
+
+// chunk is a message, or a fragment of one, inside of a data packet.
+type chunk struct {
+	channel Channel
+	id      uint16
+	index   uint8
+	count   uint8
+	data    []byte
+}
+
+func (c *chunk) size() int {
+	return chunkHeaderSize + len(c.data)
+}
+
+func (c *chunk) append(buf []byte) []byte {
+	buf = append(buf, byte(c.channel))
+	buf = binary.BigEndian.AppendUint16(buf, c.id)
+	buf = append(buf, c.index, c.count)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(c.data)))
+	return append(buf, c.data...)
+}
+
+func readChunk(buf []byte) (chunk, []byte, bool) {
+	if len(buf) < chunkHeaderSize {
+		return chunk{}, nil, false
+	}
+
+	c := chunk{
+		channel: Channel(buf[0]),
+		id:      binary.BigEndian.Uint16(buf[1:]),
+		index:   buf[3],
+		count:   buf[4],
+	}
+	size := int(binary.BigEndian.Uint16(buf[5:]))
+	buf = buf[chunkHeaderSize:]
+
+	if c.channel >= channelCount || c.count == 0 || c.index >= c.count || len(buf) < size {
+		return chunk{}, nil, false
+	}
+
+	c.data = buf[:size]
+	return c, buf[size:], true
+}
+
+// split splits the message into chunks that fit into a packet.
+func split(channel Channel, id uint16, payload []byte) []chunk {
+	count := max(1, (len(payload)+fragmentSize-1)/fragmentSize)
+	chunks := make([]chunk, count)
+	for i := range chunks {
+		end := min(len(payload), (i+1)*fragmentSize)
+		chunks[i] = chunk{
+			channel: channel,
+			id:      id,
+			index:   uint8(i),
+			count:   uint8(count),
+			data:    payload[i*fragmentSize : end],
+		}
+	}
+	return chunks
+}
+
+func handshakePacket(typ packetType, token []byte) []byte {
+	packet := make([]byte, handshakePacketSize)
+	packet[0] = byte(typ)
+	binary.BigEndian.PutUint32(packet[1:], protocolId)
+	copy(packet[5:], token)
+	return packet
+}
+
+// readHandshake validates a connect or response packet and returns its token.
+func readHandshake(packet []byte) ([]byte, bool) {
+	if len(packet) < handshakePacketSize || binary.BigEndian.Uint32(packet[1:]) != protocolId {
+		return nil, false
+	}
+	return packet[5 : 5+tokenSize], true
+}
+
+// challengeToken creates a token for the address that expires after the token lifetime.
+// Tokens are signed with the secret of the server, so it does not have to keep any state for
+// connections that have not completed the handshake, and a spoofed address never receives one.
+func challengeToken(secret []byte, addr string, now time.Time) []byte {
+	token := binary.BigEndian.AppendUint64(make([]byte, 0, tokenSize), uint64(now.Add(tokenLifetime).Unix()))
+	return append(token, tokenMac(secret, addr, token[:8])...)
+}
+
+func validToken(secret []byte, addr string, token []byte, now time.Time) bool {
+	if len(token) != tokenSize {
+		return false
+	}
+
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(token)), 0)
+	if now.After(expiry) {
+		return false
+	}
+
+	return hmac.Equal(token[8:], tokenMac(secret, addr, token[:8]))
+}
+
+// sessionSalt returns the salt of the session established with the token. Data and disconnect
+// packets carry the salt, it is part of the signature of the token which only the peer at the
+// address has seen, so packets with a spoofed address are ignored.
+func sessionSalt(token []byte) uint64 {
+	return binary.BigEndian.Uint64(token[8:])
+}
+
+// readSalt returns the salt of a data or disconnect packet.
+func readSalt(packet []byte) (uint64, bool) {
+	if len(packet) < 1+saltSize {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(packet[1:]), true
+}
+
+func tokenMac(secret []byte, addr string, expiry []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(expiry)
+	mac.Write([]byte(addr))
+	return mac.Sum(nil)[:tokenSize-8]
+}
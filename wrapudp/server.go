@@ -0,0 +1,156 @@
+package wrapudp
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+type UDPServer struct {
+	options Options
+	handler EventHandler
+	secret  []byte
+
+	mutex sync.Mutex
+	conn  *net.UDPConn
+	conns map[string]*Conn
+}
+
+func NewUDPServer(handler EventHandler, options *Options) *UDPServer {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+
+	return &UDPServer{
+		options: options.withDefaults(),
+		handler: handler,
+		secret:  secret,
+		conns:   make(map[string]*Conn),
+	}
+}
+
+// Serve listens on the address and handles connections until the server is closed.
+func (s *UDPServer) Serve(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+
+	return s.ServeConn(conn)
+}
+
+// ServeConn handles the connections of peers sending to the socket until the server is closed.
+func (s *UDPServer) ServeConn(conn *net.UDPConn) error {
+	s.mutex.Lock()
+	s.conn = conn
+	s.mutex.Unlock()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			continue
+		}
+
+		s.handlePacket(conn, addr, buf[:n])
+	}
+}
+
+func (s *UDPServer) handlePacket(socket *net.UDPConn, addr *net.UDPAddr, packet []byte) {
+	if len(packet) == 0 {
+		return
+	}
+
+	key := addr.String()
+	s.mutex.Lock()
+	conn := s.conns[key]
+	s.mutex.Unlock()
+
+	switch packetType(packet[0]) {
+	case packetConnect:
+		if _, ok := readHandshake(packet); !ok || conn != nil {
+			return
+		}
+
+		challenge := append([]byte{byte(packetChallenge)}, challengeToken(s.secret, key, time.Now())...)
+		_, _ = socket.WriteToUDP(challenge, addr)
+	case packetResponse:
+		token, ok := readHandshake(packet)
+		if !ok {
+			return
+		}
+
+		// The accept packet might have been lost, in which case the client tries again.
+		if conn != nil {
+			_, _ = socket.WriteToUDP([]byte{byte(packetAccept)}, addr)
+			return
+		}
+
+		if !validToken(s.secret, key, token, time.Now()) {
+			return
+		}
+
+		s.accept(socket, addr, sessionSalt(token))
+	case packetData, packetDisconnect:
+		if conn == nil {
+			return
+		}
+
+		conn.receive(packet)
+	}
+}
+
+func (s *UDPServer) accept(socket *net.UDPConn, addr *net.UDPAddr, salt uint64) {
+	key := addr.String()
+
+	var conn *Conn
+	conn = newConn(addr, salt, func(packet []byte) error {
+		_, err := socket.WriteToUDP(packet, addr)
+		return err
+	}, func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		if s.conns[key] == conn {
+			delete(s.conns, key)
+		}
+	}, s.options, s.handler)
+
+	s.mutex.Lock()
+	s.conns[key] = conn
+	s.mutex.Unlock()
+
+	_, _ = socket.WriteToUDP([]byte{byte(packetAccept)}, addr)
+	conn.start(context.Background())
+}
+
+// Close closes all connections and stops the server.
+func (s *UDPServer) Close() error {
+	s.mutex.Lock()
+	conns := make([]*Conn, 0, len(s.conns))
+	for _, conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	socket := s.conn
+	s.mutex.Unlock()
+
+	for _, conn := range conns {
+		_ = conn.Close()
+		<-conn.finished
+	}
+
+	if socket == nil {
+		return nil
+	}
+	return socket.Close()
+}
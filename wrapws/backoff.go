@@ -0,0 +1,37 @@
+package wrapws
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	DefaultBackoffInitial = time.Millisecond * 500
+	DefaultBackoffMax     = time.Second * 30
+)
+
+var ErrReconnectAttempts = errors.New("reconnect attempts exhausted")
+
+// Backoff configures how long DialReconnect waits between attempts to reconnect. The delay
+// starts at Initial and doubles with every failed attempt up to Max, and is reset once an
+// attempt connects.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	// MaxAttempts is the number of attempts in a row that may fail before giving up, or zero
+	// to never give up.
+	MaxAttempts int
+}
+
+func (b Backoff) withDefaults() Backoff {
+	if b.Initial <= 0 {
+		b.Initial = DefaultBackoffInitial
+	}
+	if b.Max <= 0 {
+		b.Max = DefaultBackoffMax
+	}
+	if b.Max < b.Initial {
+		b.Max = b.Initial
+	}
+	return b
+}
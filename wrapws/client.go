@@ -2,7 +2,11 @@ package wrapws
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/coder/websocket"
@@ -19,12 +23,76 @@ func NewWebSocketClient(handler EventHandler) *WebSocketClient {
 }
 
 func (ws *WebSocketClient) Dial(addr string, options *websocket.DialOptions, callback func(conn *websocket.Conn)) error {
-	ctx := context.Background()
+	_, err := ws.dial(context.Background(), addr, options, callback)
+	var response *dialError
+	if errors.As(err, &response) {
+		return response.err
+	}
+	return nil
+}
+
+// DialReconnect connects to the address like Dial, and reconnects with the backoff whenever the
+// connection is lost, until the context is done. The options are requested for every attempt,
+// so they can present the state of the previous connection, such as a session token.
+//
+// It gives up once the server closed the connection normally, for a policy violation or with a
+// status reserved for applications, or once the server refused the upgrade with a 4xx response,
+// as reconnecting would not change its mind. It returns the error the connection ended with.
+func (ws *WebSocketClient) DialReconnect(ctx context.Context, addr string, options func() *websocket.DialOptions, backoff Backoff, callback func(conn *websocket.Conn)) error {
+	backoff = backoff.withDefaults()
+
+	attempts := 0
+	delay := backoff.Initial
+	for {
+		var opts *websocket.DialOptions
+		if options != nil {
+			opts = options()
+		}
+
+		connected, err := ws.dial(ctx, addr, opts, callback)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if connected {
+			attempts, delay = 0, backoff.Initial
+		}
+		if permanent(err) {
+			return err
+		}
+
+		attempts++
+		if backoff.MaxAttempts > 0 && attempts >= backoff.MaxAttempts {
+			return fmt.Errorf("%w: %w", ErrReconnectAttempts, err)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		delay = min(delay*2, backoff.Max)
+	}
+}
 
-	conn, _, err := websocket.Dial(ctx, addr, options)
+// dialError is the error of a failed upgrade, as opposed to a connection that was lost.
+type dialError struct {
+	err      error
+	response *http.Response
+}
+
+func (e *dialError) Error() string { return e.err.Error() }
+func (e *dialError) Unwrap() error { return e.err }
+
+// dial connects once and reads until the connection is lost. It returns whether it connected,
+// and the error the upgrade or the connection ended with.
+func (ws *WebSocketClient) dial(ctx context.Context, addr string, options *websocket.DialOptions, callback func(conn *websocket.Conn)) (bool, error) {
+	conn, response, err := websocket.Dial(ctx, addr, options)
 	if err != nil {
-		return err
+		return false, &dialError{err: err, response: response}
 	}
+	ctx = withRemoteAddr(ctx, dialHost(addr))
 	defer conn.Close(websocket.StatusNormalClosure, "")
 
 	if callback != nil {
@@ -34,12 +102,34 @@ func (ws *WebSocketClient) Dial(addr string, options *websocket.DialOptions, cal
 	// Connect callback
 	ws.handler.OnConnect(ctx, conn)
 
-	_ = ws.readLoop(ctx, conn)
+	readErr := ws.readLoop(ctx, conn)
 
 	err = conn.Close(websocket.StatusNormalClosure, "")
 	ws.handler.OnDisconnect(ctx, conn, err)
 
-	return nil
+	return true, readErr
+}
+
+// permanent returns true if reconnecting after the error would not succeed.
+func permanent(err error) bool {
+	var dial *dialError
+	if errors.As(err, &dial) {
+		return dial.response != nil && dial.response.StatusCode >= 400 && dial.response.StatusCode < 500
+	}
+
+	status := websocket.CloseStatus(err)
+	return status == websocket.StatusNormalClosure ||
+		status == websocket.StatusPolicyViolation ||
+		status >= 4000
+}
+
+// dialHost returns the host of the dial address, or the address itself if it is not a URL.
+func dialHost(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		return addr
+	}
+	return u.Host
 }
 
 func (ws *WebSocketClient) readLoop(ctx context.Context, conn *websocket.Conn) error {
@@ -0,0 +1,72 @@
+package wrapws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebSocketClient_DialReconnect(t *testing.T) {
+	handler := newRecordingHandler()
+	server := NewWebSocketServer(handler, nil)
+	api := httptest.NewServer(server)
+	defer api.Close()
+
+	attempt := 0
+	options := func() *websocket.DialOptions {
+		attempt++
+		return &websocket.DialOptions{
+			HTTPHeader: http.Header{SessionHeader: []string{fmt.Sprint(attempt)}},
+		}
+	}
+
+	client := NewWebSocketClient(newRecordingHandler())
+	done := make(chan error, 1)
+	go func() {
+		done <- client.DialReconnect(context.Background(), "ws"+strings.TrimPrefix(api.URL, "http"), options, Backoff{Initial: time.Millisecond * 10}, nil)
+	}()
+
+	// The client reconnects when the server goes away, and gives up once it is closed normally.
+	conn := <-handler.connected
+	_ = conn.Close(websocket.StatusGoingAway, "restarting")
+	conn = <-handler.connected
+	_ = conn.Close(websocket.StatusNormalClosure, "")
+
+	assert.Equal(t, websocket.StatusNormalClosure, websocket.CloseStatus(<-done))
+
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	assert.Equal(t, []string{"1", "2"}, handler.sessions)
+}
+
+func TestWebSocketClient_DialReconnectRefused(t *testing.T) {
+	server := NewWebSocketServer(newRecordingHandler(), nil)
+	server.SetAuthenticator(func(req *http.Request) (any, error) {
+		return nil, errors.New("banned")
+	})
+	api := httptest.NewServer(server)
+	defer api.Close()
+	url := "ws" + strings.TrimPrefix(api.URL, "http")
+
+	client := NewWebSocketClient(newRecordingHandler())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// The server refuses the upgrade, trying again would not help.
+	err := client.DialReconnect(ctx, url, nil, Backoff{Initial: time.Millisecond}, nil)
+	assert.NotNil(t, err)
+	assert.Nil(t, ctx.Err())
+
+	// A server that is not reachable is retried until the attempts run out.
+	api.Close()
+	err = client.DialReconnect(ctx, url, nil, Backoff{Initial: time.Millisecond, MaxAttempts: 3}, nil)
+	assert.ErrorIs(t, err, ErrReconnectAttempts)
+}
@@ -0,0 +1,52 @@
+package wrapws
+
+import (
+	"context"
+	"net"
+	"net/netip"
+)
+
+type remoteAddrKey struct{}
+
+type identityKey struct{}
+
+type sessionKey struct{}
+
+// SessionHeader is the header of the upgrade request a client presents the token of its
+// session in when reconnecting.
+const SessionHeader = "Necs-Session"
+
+// addr is the address of a peer that is not an IP address, such as a host name or the peer
+// of a Unix socket.
+type addr string
+
+func (a addr) Network() string { return "websocket" }
+func (a addr) String() string  { return string(a) }
+
+// RemoteAddr returns the address of the peer of the connection, from the context passed to the
+// EventHandler. It returns nil for other contexts.
+func RemoteAddr(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(remoteAddrKey{}).(net.Addr)
+	return addr
+}
+
+func withRemoteAddr(ctx context.Context, address string) context.Context {
+	var remote net.Addr = addr(address)
+	if addrPort, err := netip.ParseAddrPort(address); err == nil {
+		remote = net.TCPAddrFromAddrPort(addrPort)
+	}
+	return context.WithValue(ctx, remoteAddrKey{}, remote)
+}
+
+// Identity returns the identity returned by the [Authenticator] of the server, from the context
+// passed to the EventHandler. It returns nil if the server has no authenticator.
+func Identity(ctx context.Context) any {
+	return ctx.Value(identityKey{})
+}
+
+// SessionToken returns the session token the client presented in the [SessionHeader] of the
+// upgrade request, from the context passed to the EventHandler.
+func SessionToken(ctx context.Context) string {
+	token, _ := ctx.Value(sessionKey{}).(string)
+	return token
+}
@@ -2,8 +2,11 @@ package wrapws
 
 import (
 	"context"
+	"errors"
 	"io"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/coder/websocket"
@@ -14,32 +17,204 @@ const maxConnectionTime = time.Hour * 12
 const idleTimeout = time.Second * 55
 const maxPingTime = time.Minute * 3
 
+var ErrServerShutdown = errors.New("server is shutting down")
+
+// shutdownReason is sent to the peers in the close frame when the server shuts down.
+const shutdownReason = "server is shutting down"
+
+// Authenticator checks the upgrade request of a connection before it is accepted, such as its
+// headers, cookies or query parameters. The identity it returns can be read by the EventHandler
+// using [Identity]. If it returns an error, the connection is rejected with 401 Unauthorized.
+type Authenticator func(req *http.Request) (identity any, err error)
+
+// netConnKey is the context key of the network connection of a request.
+type netConnKey struct{}
+
+// ConnContext can be set as the ConnContext of an [http.Server] the WebSocketServer is mounted
+// on, so the deadline of [WebSocketServer.Shutdown] also applies to peers that do not complete
+// the close handshake. Without it, such connections are given a few more seconds.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, netConnKey{}, conn)
+}
+
+// WebSocketServer accepts WebSocket connections, it is an [http.Handler] so it can be mounted
+// on any path of an existing server. It can also serve on its own using [WebSocketServer.Serve]
+// or [WebSocketServer.ServeListener].
 type WebSocketServer struct {
-	mux     *http.ServeMux
+	server  *http.Server
 	options *websocket.AcceptOptions
+	auth    Authenticator
 
 	handler EventHandler
+
+	mutex sync.Mutex
+	// conns contains the open connections, and their network connection if it is known.
+	conns    map[*websocket.Conn]net.Conn
+	shutdown bool
+	// handlers counts the accept handlers that are running, so shutdown can wait for them.
+	handlers sync.WaitGroup
 }
 
 func NewWebSocketServer(handler EventHandler, options *websocket.AcceptOptions) *WebSocketServer {
-	return &WebSocketServer{
-		mux:     http.NewServeMux(),
+	ws := &WebSocketServer{
 		options: options,
 		handler: handler,
+		conns:   make(map[*websocket.Conn]net.Conn),
+	}
+	ws.server = &http.Server{
+		Handler:     ws,
+		ConnContext: ConnContext,
 	}
+	return ws
 }
 
+// Serve listens on the TCP address and handles connections until the server is shut down.
 func (ws *WebSocketServer) Serve(addr string) error {
-	ws.mux.HandleFunc("/", ws.acceptFunc)
-
-	err := http.ListenAndServe(addr, ws.mux)
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return ws.ServeListener(listener)
+}
+
+// ServeListener handles the connections of the listener until the server is shut down, such as
+// a Unix socket listener. If the TLSConfig of the [WebSocketServer.HTTPServer] is set, the
+// connections are served over TLS using its certificates.
+func (ws *WebSocketServer) ServeListener(listener net.Listener) error {
+	var err error
+	if ws.server.TLSConfig != nil {
+		err = ws.server.ServeTLS(listener, "", "")
+	} else {
+		err = ws.server.Serve(listener)
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// HTTPServer returns the server used by [WebSocketServer.Serve] and
+// [WebSocketServer.ServeListener]. It can be configured before serving, such as its TLSConfig
+// or timeouts. Its handler should not be changed.
+func (ws *WebSocketServer) HTTPServer() *http.Server {
+	return ws.server
+}
+
+// SetAuthenticator sets the authenticator of upgrade requests, it has to be set before serving.
+func (ws *WebSocketServer) SetAuthenticator(auth Authenticator) {
+	ws.auth = auth
+}
+
+// ServeHTTP accepts the WebSocket connection of the request and handles it until it is closed.
+func (ws *WebSocketServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ws.acceptFunc(w, req)
+}
+
+// Shutdown stops accepting connections and closes the open connections with a close frame.
+// It waits until the disconnect of every connection has been handled, or until the context is
+// done, after which the remaining connections are closed without waiting for the peer.
+//
+// When the server is mounted on another [http.Server], only the WebSocket connections are
+// closed, stopping the other server is left to its owner.
+func (ws *WebSocketServer) Shutdown(ctx context.Context) error {
+	ws.mutex.Lock()
+	ws.shutdown = true
+	conns := make([]*websocket.Conn, 0, len(ws.conns))
+	for conn := range ws.conns {
+		conns = append(conns, conn)
+	}
+	ws.mutex.Unlock()
+
+	// WebSockets are hijacked, so this only stops the listener and waits for other requests.
+	err := ws.server.Shutdown(ctx)
+
+	for _, conn := range conns {
+		go conn.Close(websocket.StatusGoingAway, shutdownReason)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ws.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Closing the network connection also ends close handshakes that are in progress.
+	ws.mutex.Lock()
+	for conn, netConn := range ws.conns {
+		if netConn != nil {
+			_ = netConn.Close()
+		} else {
+			go conn.CloseNow()
+		}
+	}
+	ws.mutex.Unlock()
+
+	// The handlers return right away once their connections are closed.
+	<-done
+	return ctx.Err()
+}
+
+// track registers a running accept handler, it returns false once the server is shutting down.
+func (ws *WebSocketServer) track() bool {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	if ws.shutdown {
+		return false
+	}
+	ws.handlers.Add(1)
+	return true
+}
+
+// open registers an accepted connection, it returns false once the server is shutting down.
+func (ws *WebSocketServer) open(conn *websocket.Conn, netConn net.Conn) bool {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	if ws.shutdown {
+		return false
+	}
+	ws.conns[conn] = netConn
+	return true
 }
+
+// closed forgets the connection, and returns whether the server is shutting down.
+func (ws *WebSocketServer) closed(conn *websocket.Conn) bool {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	delete(ws.conns, conn)
+	return ws.shutdown
+}
+
 func (ws *WebSocketServer) acceptFunc(w http.ResponseWriter, req *http.Request) {
+	if !ws.track() {
+		http.Error(w, shutdownReason, http.StatusServiceUnavailable)
+		return
+	}
+	defer ws.handlers.Done()
+
+	ctx := withRemoteAddr(req.Context(), req.RemoteAddr)
+	if token := req.Header.Get(SessionHeader); token != "" {
+		ctx = context.WithValue(ctx, sessionKey{}, token)
+	}
+	if ws.auth != nil {
+		identity, err := ws.auth(req)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		ctx = context.WithValue(ctx, identityKey{}, identity)
+	}
+
 	conn, err := websocket.Accept(w, req, ws.options)
 	if err != nil {
 		return
@@ -51,10 +226,19 @@ func (ws *WebSocketServer) acceptFunc(w http.ResponseWriter, req *http.Request)
 
 	defer conn.CloseNow()
 
-	ws.readLoop(req.Context(), conn)
+	netConn, _ := req.Context().Value(netConnKey{}).(net.Conn)
+	if !ws.open(conn, netConn) {
+		_ = conn.Close(websocket.StatusGoingAway, shutdownReason)
+		return
+	}
+
+	ws.readLoop(ctx, conn)
 
 	err = conn.Close(websocket.StatusNormalClosure, "")
-	ws.handler.OnDisconnect(req.Context(), conn, err)
+	if ws.closed(conn) {
+		err = ErrServerShutdown
+	}
+	ws.handler.OnDisconnect(ctx, conn, err)
 }
 
 func (ws *WebSocketServer) readLoop(ctx context.Context, conn *websocket.Conn) error {
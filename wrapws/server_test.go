@@ -0,0 +1,213 @@
+package wrapws
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingHandler struct {
+	mutex       sync.Mutex
+	connected   chan *websocket.Conn
+	identities  []any
+	sessions    []string
+	disconnects []error
+}
+
+func newRecordingHandler() *recordingHandler {
+	return &recordingHandler{connected: make(chan *websocket.Conn, 8)}
+}
+
+func (h *recordingHandler) OnConnect(ctx context.Context, conn *websocket.Conn) {
+	h.mutex.Lock()
+	h.identities = append(h.identities, Identity(ctx))
+	h.sessions = append(h.sessions, SessionToken(ctx))
+	h.mutex.Unlock()
+
+	h.connected <- conn
+}
+
+func (h *recordingHandler) OnDisconnect(ctx context.Context, conn *websocket.Conn, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.disconnects = append(h.disconnects, err)
+}
+
+func (h *recordingHandler) OnError(ctx context.Context, conn *websocket.Conn, err error) {}
+
+func (h *recordingHandler) OnMessage(ctx context.Context, conn *websocket.Conn, payload []byte) {}
+
+func (h *recordingHandler) disconnected() []error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return append([]error(nil), h.disconnects...)
+}
+
+func TestWebSocketServer_Shutdown(t *testing.T) {
+	handler := newRecordingHandler()
+	server := NewWebSocketServer(handler, nil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ServeListener(listener)
+	}()
+
+	url := "ws://" + listener.Addr().String()
+	var clients []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.Dial(context.Background(), url, nil)
+		assert.Nil(t, err)
+		clients = append(clients, conn)
+		<-handler.connected
+	}
+
+	// The clients answer the close frame of the server while reading.
+	statuses := make(chan websocket.StatusCode, len(clients))
+	for _, conn := range clients {
+		go func() {
+			_, _, err := conn.Read(context.Background())
+			statuses <- websocket.CloseStatus(err)
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	assert.Nil(t, server.Shutdown(ctx))
+	assert.Nil(t, <-served)
+
+	for range clients {
+		assert.Equal(t, websocket.StatusGoingAway, <-statuses)
+	}
+	assert.Equal(t, []error{ErrServerShutdown, ErrServerShutdown}, handler.disconnected())
+
+	// New connections are refused.
+	_, _, err = websocket.Dial(context.Background(), url, nil)
+	assert.NotNil(t, err)
+}
+
+func TestWebSocketServer_ShutdownDeadline(t *testing.T) {
+	handler := newRecordingHandler()
+	server := NewWebSocketServer(handler, nil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() {
+		_ = server.ServeListener(listener)
+	}()
+
+	// The client never reads, so it never answers the close frame.
+	conn, _, err := websocket.Dial(context.Background(), "ws://"+listener.Addr().String(), nil)
+	assert.Nil(t, err)
+	defer conn.CloseNow()
+	<-handler.connected
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	assert.ErrorIs(t, server.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Len(t, handler.disconnected(), 1)
+}
+
+func TestWebSocketServer_Mounted(t *testing.T) {
+	handler := newRecordingHandler()
+	server := NewWebSocketServer(handler, nil)
+
+	mux := http.NewServeMux()
+	mux.Handle("/game", server)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	api := httptest.NewServer(mux)
+	defer api.Close()
+
+	response, err := http.Get(api.URL + "/health")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+	_ = response.Body.Close()
+
+	conn, _, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(api.URL, "http")+"/game", nil)
+	assert.Nil(t, err)
+	defer conn.CloseNow()
+	<-handler.connected
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		_, _, _ = conn.Read(context.Background())
+	}()
+	assert.Nil(t, server.Shutdown(ctx))
+	assert.Len(t, handler.disconnected(), 1)
+
+	// The other routes keep being served.
+	response, err = http.Get(api.URL + "/health")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+	_ = response.Body.Close()
+}
+
+func TestWebSocketServer_UnixSocket(t *testing.T) {
+	handler := newRecordingHandler()
+	server := NewWebSocketServer(handler, nil)
+
+	path := filepath.Join(t.TempDir(), "game.sock")
+	listener, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	go func() {
+		_ = server.ServeListener(listener)
+	}()
+	defer server.Shutdown(context.Background())
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}}
+	conn, _, err := websocket.Dial(context.Background(), "ws://game", &websocket.DialOptions{HTTPClient: client})
+	assert.Nil(t, err)
+	defer conn.CloseNow()
+
+	<-handler.connected
+}
+
+func TestWebSocketServer_Authenticator(t *testing.T) {
+	handler := newRecordingHandler()
+	server := NewWebSocketServer(handler, nil)
+	server.SetAuthenticator(func(req *http.Request) (any, error) {
+		token := req.URL.Query().Get("token")
+		if token != "secret" {
+			return nil, errors.New("invalid token")
+		}
+		return "player", nil
+	})
+
+	api := httptest.NewServer(server)
+	defer api.Close()
+	url := "ws" + strings.TrimPrefix(api.URL, "http")
+
+	_, response, err := websocket.Dial(context.Background(), url+"?token=guess", nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	conn, _, err := websocket.Dial(context.Background(), url+"?token=secret", nil)
+	assert.Nil(t, err)
+	defer conn.CloseNow()
+	<-handler.connected
+
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	assert.Equal(t, []any{"player"}, handler.identities)
+}